@@ -0,0 +1,19 @@
+package api
+
+import (
+	"errors"
+)
+
+//fn返回ErrAuthExpired时刷新serviceToken后重试一次，刷新失败返回ErrAuthExpired
+func (api *api) withAuth(fn func() error) error {
+	err := fn()
+	if !errors.Is(err, ErrAuthExpired) {
+		return err
+	}
+	api.logger.Debugf("service token expired, refreshing")
+	if err := api.user.RefreshToken(); err != nil {
+		api.logger.Errorf("refresh service token failed, error: %s", err)
+		return ErrAuthExpired
+	}
+	return fn()
+}
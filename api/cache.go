@@ -0,0 +1,124 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+//目录列表及文件信息的内存缓存，ttl<=0时不缓存
+type metaCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	folders map[string]folderEntry
+	files   map[string]fileEntry
+}
+
+type folderEntry struct {
+	files   []*File
+	expires time.Time
+}
+
+type fileEntry struct {
+	file    *File
+	expires time.Time
+}
+
+func newMetaCache(ttl time.Duration) *metaCache {
+	return &metaCache{
+		ttl:     ttl,
+		folders: make(map[string]folderEntry),
+		files:   make(map[string]fileEntry),
+	}
+}
+
+func (c *metaCache) getFolder(id string) ([]*File, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.folders[id]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.folders, id)
+		return nil, false
+	}
+	return copyFiles(entry.files), true
+}
+
+func (c *metaCache) setFolder(id string, files []*File) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.folders[id] = folderEntry{files: copyFiles(files), expires: time.Now().Add(c.ttl)}
+}
+
+//复制文件列表，避免调用方修改缓存中的File
+func copyFiles(files []*File) []*File {
+	var copied = make([]*File, len(files))
+	for i, v := range files {
+		file := *v
+		copied[i] = &file
+	}
+	return copied
+}
+
+func (c *metaCache) getFile(id string) (*File, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.files[id]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.files, id)
+		return nil, false
+	}
+	file := *entry.file
+	return &file, true
+}
+
+func (c *metaCache) setFile(file *File) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	copied := *file
+	c.files[file.Id] = fileEntry{file: &copied, expires: time.Now().Add(c.ttl)}
+}
+
+//删除id对应的缓存，以及包含该id的目录列表
+func (c *metaCache) invalidate(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.files, id)
+	delete(c.folders, id)
+	for folderId, entry := range c.folders {
+		for _, v := range entry.files {
+			if v.Id == id {
+				delete(c.folders, folderId)
+				break
+			}
+		}
+	}
+}
+
+func (c *metaCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.folders = make(map[string]folderEntry)
+	c.files = make(map[string]fileEntry)
+}
+
+//清除文件或目录id相关的缓存，上传、删除、移动、重命名等操作会自动调用
+func (api *api) InvalidateCache(id string) {
+	api.cache.invalidate(id)
+}
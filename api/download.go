@@ -0,0 +1,543 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//断点续传下载的状态，保存在下载文件旁的状态文件中，下载完成后删除
+type downloadState struct {
+	Id      string `json:"id"`
+	Sha1    string `json:"sha1"`
+	Size    int64  `json:"size"`
+	Written int64  `json:"written"`
+}
+
+func downloadStatePath(filePath string) string {
+	return filePath + ".micloud-download"
+}
+
+//读取状态文件，不存在时返回nil
+func loadDownloadState(filePath string) (*downloadState, error) {
+	data, err := ioutil.ReadFile(downloadStatePath(filePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &downloadState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *downloadState) save(filePath string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(downloadStatePath(filePath), data, 0644)
+}
+
+//根据filePath旁的状态文件继续之前中断的下载
+func (api *api) ResumeDownload(filePath string) error {
+	state, err := loadDownloadState(filePath)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no download state for %s", filePath)
+	}
+	return api.DownloadFileResumable(state.Id, filePath)
+}
+
+//断点续传下载文件到filePath，下载过程中在filePath旁保存状态文件
+//状态文件与云盘中的文件一致时从记录的位置继续下载，不一致时重新下载，没有状态文件时从已存在的部分文件末尾继续下载
+//下载完成后校验sha1，不一致则重新完整下载一次
+func (api *api) DownloadFileResumable(id string, filePath string) error {
+	ctx := context.Background()
+	storage, err := api.getFileStorage(ctx, id)
+	if err != nil {
+		return err
+	}
+	state, err := loadDownloadState(filePath)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		if state.Id != id || state.Size != storage.size || state.Sha1 != storage.sha1 {
+			api.logger.Debugf("download state of %s does not match file %s, starting over", filePath, id)
+			offset = 0
+		} else if state.Written < offset {
+			offset = state.Written
+		}
+		if err := file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	state = &downloadState{Id: id, Sha1: storage.sha1, Size: storage.size}
+	save := func(written int64) {
+		state.Written = written
+		if err := state.save(filePath); err != nil {
+			api.logger.Errorf("save download state failed, error: %s", err)
+		}
+	}
+	for retried := false; ; retried = true {
+		if err := api.downloadFrom(ctx, storage, file, offset, save); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if storage.sha1 == "" || calHash(file, "sha1") == storage.sha1 {
+			_ = os.Remove(downloadStatePath(filePath))
+			return api.setModTime(filePath, storage.modTime)
+		}
+		if retried {
+			return ErrSha1Mismatch
+		}
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		offset = 0
+	}
+}
+
+//从offset处继续下载并写入file，save不为空时每写入一个分片大小调用一次，参数为已写入的总字节数
+func (api *api) downloadFrom(ctx context.Context, storage *fileStorage, file *os.File, offset int64,
+	save func(written int64)) error {
+	if storage.size > 0 && offset >= storage.size {
+		return nil
+	}
+	resp, err := api.openFileStorage(ctx, storage, offset)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	//先确认响应状态再写入，出错时保留已下载的部分和状态文件
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		//服务端不支持Range，从头下载
+		if offset > 0 {
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+			offset = 0
+		}
+	default:
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, statusErrorBodySize))
+		return newStatusError(resp, body)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if save == nil {
+		_, err = io.Copy(file, resp.Body)
+		return err
+	}
+	save(offset)
+	for {
+		n, err := io.CopyN(file, resp.Body, api.chunkSize)
+		offset += n
+		if n > 0 {
+			save(offset)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//下载文件并校验sha1，与云盘记录不一致时返回ErrSha1Mismatch
+func (api *api) GetFileVerified(id string) ([]byte, error) {
+	ctx := context.Background()
+	storage, err := api.getFileStorage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if storage.sha1 == "" {
+		return nil, errors.New("get file sha1 failed")
+	}
+	resp, err := api.openFileStorage(ctx, storage, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	all, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if calHash(bytes.NewReader(all), "sha1") != storage.sha1 {
+		return nil, ErrSha1Mismatch
+	}
+	return all, nil
+}
+
+//下载文件[start, end]范围内的字节，end超过文件末尾时读到末尾为止
+func (api *api) GetFileRange(id string, start, end int64) ([]byte, error) {
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("invalid range %d-%d", start, end)
+	}
+	ctx := context.Background()
+	storage, err := api.getFileStorage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if storage.size > 0 && start >= storage.size {
+		return nil, fmt.Errorf("range start %d beyond file size %d", start, storage.size)
+	}
+	resp, err := api.openFileRange(ctx, storage, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		//服务端不支持Range时跳过前面的内容
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, start); err != nil {
+			return nil, err
+		}
+	default:
+		if err := checkStatus(resp); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("download range %d-%d failed, status: %d", start, end, resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, end-start+1))
+}
+
+//分段并发下载文件到destPath，每个协程下载一段并写入对应偏移，完成后校验sha1
+//服务端不支持Range请求时退化为单线程下载
+func (api *api) DownloadParallel(id string, destPath string, workers int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage, err := api.getFileStorage(ctx, id)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if workers < 1 {
+		workers = 1
+	}
+	segment := (storage.size + int64(workers) - 1) / int64(workers)
+	if segment <= 0 {
+		segment = storage.size
+	}
+	//用第一段请求探测服务端是否支持Range
+	resp, err := api.openFileRange(ctx, storage, 0, segment-1)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent || workers == 1 || storage.size <= 0 {
+		_, err = io.Copy(file, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if err := verifyFile(file, storage.sha1); err != nil {
+			return err
+		}
+		return api.setModTime(destPath, storage.modTime)
+	}
+	if err := file.Truncate(storage.size); err != nil {
+		resp.Body.Close()
+		return err
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	setErr := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+	for start := int64(0); start < storage.size; start += segment {
+		end := start + segment - 1
+		if end >= storage.size {
+			end = storage.size - 1
+		}
+		wg.Add(1)
+		go func(start, end int64, resp *http.Response) {
+			defer wg.Done()
+			if resp == nil {
+				var err error
+				resp, err = api.openFileRange(ctx, storage, start, end)
+				if err != nil {
+					setErr(err)
+					return
+				}
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusPartialContent {
+				setErr(fmt.Errorf("download range %d-%d failed, status: %d", start, end, resp.StatusCode))
+				return
+			}
+			n, err := io.Copy(&offsetWriter{file: file, offset: start}, resp.Body)
+			if err == nil && n != end-start+1 {
+				err = fmt.Errorf("download range %d-%d failed, got %d bytes", start, end, n)
+			}
+			if err != nil {
+				setErr(err)
+			}
+		}(start, end, resp)
+		resp = nil
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := verifyFile(file, storage.sha1); err != nil {
+		return err
+	}
+	return api.setModTime(destPath, storage.modTime)
+}
+
+//下载文件到destPath并按分片校验sha1，只用Range重新下载校验失败的分片
+//元数据中没有分片信息时只校验整个文件的sha1
+func (api *api) DownloadBlockVerified(id string, destPath string) error {
+	ctx := context.Background()
+	storage, err := api.getFileStorage(ctx, id)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(destPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := api.downloadFrom(ctx, storage, file, 0, nil); err != nil {
+		return err
+	}
+	var offset int64
+	for i, block := range storage.blocks {
+		if block.Sha1 == "" {
+			offset += block.Size
+			continue
+		}
+		section := io.NewSectionReader(file, offset, block.Size)
+		if calHash(section, "sha1") != block.Sha1 {
+			api.logger.Errorf("block %d of file %s corrupted, downloading again", i, id)
+			if err := api.downloadBlock(ctx, storage, file, offset, block); err != nil {
+				return fmt.Errorf("download block %d failed, error: %w", i, err)
+			}
+		}
+		offset += block.Size
+	}
+	if err := verifyFile(file, storage.sha1); err != nil {
+		return err
+	}
+	return api.setModTime(destPath, storage.modTime)
+}
+
+//重新下载offset处的分片并写入file，下载后再次校验sha1
+func (api *api) downloadBlock(ctx context.Context, storage *fileStorage, file *os.File, offset int64, block BlockInfo) error {
+	resp, err := api.openFileRange(ctx, storage, offset, offset+block.Size-1)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request not supported, status: %d", resp.StatusCode)
+	}
+	n, err := io.Copy(&offsetWriter{file: file, offset: offset}, io.LimitReader(resp.Body, block.Size))
+	if err != nil {
+		return err
+	}
+	if n != block.Size {
+		return fmt.Errorf("got %d bytes, expected %d", n, block.Size)
+	}
+	if calHash(io.NewSectionReader(file, offset, block.Size), "sha1") != block.Sha1 {
+		return ErrSha1Mismatch
+	}
+	return nil
+}
+
+//按偏移写入文件
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+//校验文件sha1，sha1为空时不校验
+func verifyFile(file *os.File, sha1 string) error {
+	if sha1 == "" {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if calHash(file, "sha1") != sha1 {
+		return ErrSha1Mismatch
+	}
+	return nil
+}
+
+//下载整个目录到destDir，保持目录结构，本地已存在且sha1相同的文件跳过
+//单个文件失败不会中断下载，结束后以MultiError返回所有失败的文件
+func (api *api) DownloadFolder(folderId string, destDir string) error {
+	_, err := api.DownloadFolderWithOptions(folderId, destDir, FolderOptions{})
+	return err
+}
+
+//按指定设置下载整个目录，返回每个文件的处理结果，DryRun时只返回计划不下载
+func (api *api) DownloadFolderWithOptions(folderId string, destDir string, opts FolderOptions) (*SyncPlan, error) {
+	var (
+		failed = make(map[string]error)
+		plan   = &SyncPlan{}
+	)
+	if err := api.downloadFolder(context.Background(), folderId, destDir, opts, plan, failed); err != nil {
+		return nil, err
+	}
+	if len(failed) > 0 {
+		return plan, &MultiError{Failed: failed}
+	}
+	return plan, nil
+}
+
+func (api *api) downloadFolder(ctx context.Context, folderId string, destDir string, opts FolderOptions,
+	plan *SyncPlan, failed map[string]error) error {
+	if !opts.DryRun {
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return err
+		}
+	}
+	files, err := api.GetFolderContext(ctx, folderId)
+	if err != nil {
+		return err
+	}
+	for _, v := range files {
+		localPath := filepath.Join(destDir, v.Name)
+		if v.IsFolder() {
+			if err := api.downloadFolder(ctx, v.Id, localPath, opts, plan, failed); err != nil {
+				failed[localPath] = err
+			}
+			continue
+		}
+		if _, err := os.Stat(localPath); err != nil {
+			plan.Create = append(plan.Create, localPath)
+		} else if v.Sha1 != "" && calFileHash(localPath, "sha1") == v.Sha1 {
+			plan.Skip = append(plan.Skip, localPath)
+			continue
+		} else {
+			plan.Overwrite = append(plan.Overwrite, localPath)
+		}
+		if opts.DryRun {
+			continue
+		}
+		if err := api.downloadTo(ctx, v.Id, localPath, v.ModifyTime); err != nil {
+			failed[localPath] = err
+		}
+	}
+	return nil
+}
+
+//下载文件到localPath，modTime为云盘中的修改时间，下载失败时保留本地原有的文件
+func (api *api) downloadTo(ctx context.Context, id string, localPath string, modTime time.Time) error {
+	if err := api.downloadReplace(ctx, id, localPath); err != nil {
+		return err
+	}
+	return api.setModTime(localPath, modTime)
+}
+
+//开启WithPreserveModTime时将本地文件的修改时间设置为云盘中的修改时间
+func (api *api) setModTime(localPath string, modTime time.Time) error {
+	if !api.preserveMtime || modTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(localPath, modTime, modTime)
+}
+
+//下载文件到destPath，先写入同目录下的临时文件，成功后再重命名，失败或ctx取消时删除临时文件
+func (api *api) DownloadFileContext(ctx context.Context, id string, destPath string) error {
+	if err := api.downloadReplace(ctx, id, destPath); err != nil {
+		return err
+	}
+	if api.preserveMtime {
+		info, err := api.GetFileInfo(id)
+		if err != nil {
+			return err
+		}
+		return api.setModTime(destPath, info.ModifyTime)
+	}
+	return nil
+}
+
+//写入同目录下的临时文件，成功后重命名为destPath，失败时destPath保持不变
+func (api *api) downloadReplace(ctx context.Context, id string, destPath string) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(destPath), "."+filepath.Base(destPath)+".part-")
+	if err != nil {
+		return err
+	}
+	_, err = api.getFileTo(ctx, id, tmpFile, nil)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	//TempFile创建的文件权限为0600，改为与其他下载方式一致的0644
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), destPath); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGetFileStorageError(t *testing.T) {
+	d := newFakeDrive(t)
+	id := d.addFile(RootFolderId, "a.txt", []byte("hello"))
+	d.storageStatus = http.StatusInternalServerError
+	api := d.api(WithRetry(1, 0))
+	data, err := api.GetFile(id)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("GetFile() error = %v, want StatusError 500", err)
+	}
+	if data != nil {
+		t.Fatalf("GetFile() returned %q with error", data)
+	}
+	d.storageStatus = 0
+	data, err = api.GetFile(id)
+	if err != nil || !bytes.Equal(data, []byte("hello")) {
+		t.Fatalf("GetFile() = %q, %v", data, err)
+	}
+}
+
+func TestDownloadFileResumableKeepsPartialOnError(t *testing.T) {
+	d := newFakeDrive(t)
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	id := d.addFile(RootFolderId, "a.bin", content)
+	filePath := filepath.Join(t.TempDir(), "a.bin")
+	if err := ioutil.WriteFile(filePath, content[:300], 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := &downloadState{Id: id, Sha1: fmt.Sprintf("%x", sha1.Sum(content)), Size: int64(len(content)), Written: 300}
+	if err := state.save(filePath); err != nil {
+		t.Fatal(err)
+	}
+	stateData, _ := ioutil.ReadFile(downloadStatePath(filePath))
+	api := d.api(WithRetry(1, 0))
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusForbidden} {
+		d.storageStatus = status
+		err := api.DownloadFileResumable(id, filePath)
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != status {
+			t.Fatalf("DownloadFileResumable() error = %v, want StatusError %d", err, status)
+		}
+		if data, _ := ioutil.ReadFile(filePath); !bytes.Equal(data, content[:300]) {
+			t.Fatalf("partial file changed to %d bytes after %d", len(data), status)
+		}
+		if data, _ := ioutil.ReadFile(downloadStatePath(filePath)); !bytes.Equal(data, stateData) {
+			t.Fatalf("download state changed to %s after %d", data, status)
+		}
+	}
+	d.storageStatus = 0
+	if err := api.ResumeDownload(filePath); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filePath); !bytes.Equal(data, content) {
+		t.Fatalf("resumed file has %d bytes, want %d", len(data), len(content))
+	}
+	if _, err := os.Stat(downloadStatePath(filePath)); !os.IsNotExist(err) {
+		t.Fatalf("download state not removed, error: %v", err)
+	}
+}
+
+//顺序下载多个文件应复用空闲连接，响应内容需读完并关闭
+func TestDownloadReusesConnections(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, d.addFile(RootFolderId, fmt.Sprintf("%d.txt", i), bytes.Repeat([]byte{byte(i)}, 1024)))
+	}
+	dir := t.TempDir()
+	for i := 0; i < 4; i++ {
+		for k, id := range ids {
+			if _, err := api.GetFile(id); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := api.GetFileRange(id, 0, 9); err != nil {
+				t.Fatal(err)
+			}
+			if err := api.DownloadFileContext(context.Background(), id, filepath.Join(dir, fmt.Sprint(k))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if conns := atomic.LoadInt64(&d.conns); conns > 2 {
+		t.Fatalf("%d connections opened for sequential downloads, want idle connections reused", conns)
+	}
+}
+
+func TestDownloadFileContextMode(t *testing.T) {
+	d := newFakeDrive(t)
+	id := d.addFile(RootFolderId, "a.txt", []byte("hello"))
+	destPath := filepath.Join(t.TempDir(), "a.txt")
+	if err := d.api().DownloadFileContext(context.Background(), id, destPath); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Fatalf("downloaded file mode = %o, want 644", mode)
+	}
+}
+
+//覆盖下载失败时保留本地原有的文件
+func TestDownloadFolderKeepsLocalFileOnError(t *testing.T) {
+	d := newFakeDrive(t)
+	d.addFile(RootFolderId, "a.txt", []byte("remote"))
+	d.storageStatus = http.StatusInternalServerError
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(localPath, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := d.api(WithRetry(1, 0)).DownloadFolderWithOptions(RootFolderId, dir, FolderOptions{})
+	var multiErr *MultiError
+	if !errors.As(err, &multiErr) || multiErr.Failed[localPath] == nil {
+		t.Fatalf("DownloadFolderWithOptions() error = %v, want failure for %s", err, localPath)
+	}
+	if len(plan.Overwrite) != 1 {
+		t.Fatalf("plan = %+v, want a.txt in Overwrite", plan)
+	}
+	if data, _ := ioutil.ReadFile(localPath); string(data) != "local" {
+		t.Fatalf("local file changed to %q after failed download", data)
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("%d entries left in %s, want temp file removed", len(entries), dir)
+	}
+}
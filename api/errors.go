@@ -0,0 +1,159 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	//未登录或登录已过期
+	ErrAuthExpired = ErrorNotLogin
+	//文件或目录不存在
+	ErrNotFound = errors.New("not found")
+	//云盘空间不足
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	//同名文件或目录已存在
+	ErrAlreadyExists = errors.New("already exists")
+	ErrFileExists    = ErrAlreadyExists
+	//文件没有可用的缩略图等资源
+	ErrNotAvailable = errors.New("not available")
+	//创建文件接口没有返回可用的上传节点
+	ErrNoUploadNode = errors.New("no available upload node")
+	//服务端要求的分片大小与本次上传使用的不一致
+	ErrBlockSizeMismatch = errors.New("block size mismatch")
+	//id对应的是目录或没有下载地址，无法下载
+	ErrNotDownloadable = errors.New("not downloadable")
+)
+
+//下载的文件与云盘记录的sha1不一致
+var ErrSha1Mismatch = errors.New("sha1 of downloaded file mismatch")
+
+//分片上传失败，Index为第一个失败的分片序号
+type UploadBlockError struct {
+	Index int
+	Total int
+	Err   error
+}
+
+func (e *UploadBlockError) Error() string {
+	return fmt.Sprintf("upload block %d/%d failed, error: %s", e.Index+1, e.Total, e.Err)
+}
+
+func (e *UploadBlockError) Unwrap() error {
+	return e.Err
+}
+
+//请求返回非2xx状态码，Body为响应内容的开头部分，RetryAfter为响应中Retry-After要求的等待时间
+type StatusError struct {
+	Path       string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+	err        error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request %s failed, status: %d, body: %s", e.Path, e.StatusCode, e.Body)
+}
+
+//401映射为ErrAuthExpired，404映射为ErrNotFound，响应为接口错误json时映射为对应的ApiError
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+//错误信息中保留的响应内容长度
+const statusErrorBodySize = 256
+
+func newStatusError(resp *http.Response, body []byte) error {
+	statusErr := &StatusError{
+		Path:       resp.Request.URL.Path,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	if len(body) > statusErrorBodySize {
+		statusErr.Body = string(body[:statusErrorBodySize]) + "..."
+	} else {
+		statusErr.Body = string(body)
+	}
+	switch result := gjson.ParseBytes(body); {
+	case resp.StatusCode == http.StatusUnauthorized:
+		statusErr.err = ErrAuthExpired
+	case resp.StatusCode == http.StatusNotFound:
+		statusErr.err = ErrNotFound
+	case gjson.ValidBytes(body) && result.Get("result").Exists():
+		statusErr.err = newServerError(result)
+	}
+	return statusErr
+}
+
+//批量操作中部分失败，Failed记录失败的文件及原因
+type MultiError struct {
+	Failed map[string]error
+}
+
+func (e *MultiError) Error() string {
+	var names = make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var msgs = make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, name+": "+e.Failed[name].Error())
+	}
+	return fmt.Sprintf("%d failed, %s", len(names), strings.Join(msgs, "; "))
+}
+
+//接口返回的错误，Code不受语言影响，可用于判断错误类型
+type ApiError struct {
+	Code        int
+	Result      string
+	Description string
+	err         error
+}
+
+func (e *ApiError) Error() string {
+	var msg = e.Description
+	if msg == "" {
+		msg = fmt.Sprintf("request failed, result: %s, code: %d", e.Result, e.Code)
+	}
+	if e.err != nil {
+		return e.err.Error() + ": " + msg
+	}
+	return msg
+}
+
+//返回映射的错误类型，如ErrNotFound，以便errors.Is判断
+func (e *ApiError) Unwrap() error {
+	return e.err
+}
+
+//将接口返回的错误映射为对应的错误类型，错误信息中保留原始的description
+func newServerError(result gjson.Result) error {
+	var (
+		code        = result.Get("code").Int()
+		description = result.Get("description").String()
+		lower       = strings.ToLower(description)
+		apiErr      = &ApiError{
+			Code:        int(code),
+			Result:      result.Get("result").String(),
+			Description: description,
+		}
+	)
+	switch {
+	case result.Get("R").Int() == 401 || code == 401:
+		apiErr.err = ErrAuthExpired
+	case code == 404 || strings.Contains(lower, "not exist") || strings.Contains(lower, "not found"):
+		apiErr.err = ErrNotFound
+	case strings.Contains(lower, "quota") || strings.Contains(description, "空间不足"):
+		apiErr.err = ErrQuotaExceeded
+	case strings.Contains(lower, "already exist") || strings.Contains(description, "已存在"):
+		apiErr.err = ErrAlreadyExists
+	}
+	return apiErr
+}
@@ -0,0 +1,324 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"go-micloud/user"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//模拟云盘接口的测试服务器，文件内容保存在内存中
+type fakeDrive struct {
+	srv *httptest.Server
+	mu  sync.Mutex
+	//自增id
+	seq   int
+	files map[string]*fakeFile
+	//已上传的分片，键为分片sha1
+	blocks map[string][]byte
+	//创建文件接口返回的上传信息，键为uploadId
+	uploads map[string]*fakeUpload
+	//收到的upload_block_chunk请求地址
+	blockUrls []string
+	//不为0时存储节点返回该状态码
+	storageStatus int
+	//返回true时该分片上传返回500
+	failBlock func(blockMeta string) bool
+	//不为nil时创建文件接口返回的node_urls
+	nodeUrls []string
+	//建立过的连接数
+	conns int64
+	//每个目录的列表请求数
+	listings map[string]int
+	//创建该名称的目录时返回错误
+	failFolder string
+	//创建目录的请求数
+	folderCreates int
+}
+
+type fakeFile struct {
+	id       string
+	parentId string
+	name     string
+	folder   bool
+	data     []byte
+}
+
+type fakeUpload struct {
+	name   string
+	sha1   string
+	blocks []string
+}
+
+func newFakeDrive(t *testing.T) *fakeDrive {
+	d := &fakeDrive{
+		files:    map[string]*fakeFile{RootFolderId: {id: RootFolderId, name: "/", folder: true}},
+		blocks:   make(map[string][]byte),
+		uploads:  make(map[string]*fakeUpload),
+		listings: make(map[string]int),
+	}
+	d.srv = httptest.NewUnstartedServer(http.HandlerFunc(d.serve))
+	d.srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&d.conns, 1)
+		}
+	}
+	d.srv.Start()
+	t.Cleanup(d.srv.Close)
+	return d
+}
+
+//创建使用测试服务器的api，重试等待时间缩短为1ms
+func (d *fakeDrive) api(opts ...Option) *api {
+	opts = append([]Option{WithBaseUri(d.srv.URL), WithRetry(3, time.Millisecond)}, opts...)
+	return NewApi(user.NewUser(), opts...).(*api)
+}
+
+func (d *fakeDrive) addFile(parentId, name string, data []byte) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.add(&fakeFile{parentId: parentId, name: name, data: data})
+}
+
+func (d *fakeDrive) addFolder(parentId, name string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.add(&fakeFile{parentId: parentId, name: name, folder: true})
+}
+
+func (d *fakeDrive) add(f *fakeFile) string {
+	d.seq++
+	f.id = strconv.Itoa(d.seq)
+	d.files[f.id] = f
+	return f.id
+}
+
+func (d *fakeDrive) file(id string) *fakeFile {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.files[id]
+}
+
+func (d *fakeDrive) hasBlock(sha1Hex string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.blocks[sha1Hex]
+	return ok
+}
+
+func (f *fakeFile) json() map[string]interface{} {
+	m := map[string]interface{}{
+		"id":         f.id,
+		"name":       f.name,
+		"parentId":   f.parentId,
+		"modifyTime": 1577836800000,
+	}
+	if f.folder {
+		m["type"] = "folder"
+	} else {
+		m["type"] = "file"
+		m["size"] = len(f.data)
+		m["sha1"] = fmt.Sprintf("%x", sha1.Sum(f.data))
+	}
+	return m
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	data, _ := json.Marshal(v)
+	_, _ = w.Write(data)
+}
+
+func (d *fakeDrive) serve(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := r.URL.Path
+	switch {
+	case strings.HasPrefix(p, "/drive/user/folders/") && strings.HasSuffix(p, "/children"):
+		id := strings.TrimSuffix(strings.TrimPrefix(p, "/drive/user/folders/"), "/children")
+		d.listings[id]++
+		list := make([]interface{}, 0)
+		for _, v := range d.files {
+			if v.parentId == id && v.id != RootFolderId {
+				list = append(list, v.json())
+			}
+		}
+		writeJson(w, map[string]interface{}{"result": "ok", "data": map[string]interface{}{"list": list}})
+	case strings.HasPrefix(p, "/drive/user/folders/") && strings.HasSuffix(p, "/delete"):
+		id := strings.TrimSuffix(strings.TrimPrefix(p, "/drive/user/folders/"), "/delete")
+		for _, v := range d.files {
+			if v.parentId == id {
+				writeJson(w, map[string]interface{}{"result": "error", "description": "folder not empty"})
+				return
+			}
+		}
+		delete(d.files, id)
+		writeJson(w, map[string]interface{}{"result": "ok"})
+	case p == "/drive/user/folders" && r.Method == "POST":
+		d.folderCreates++
+		if d.failFolder != "" && r.FormValue("name") == d.failFolder {
+			writeJson(w, map[string]interface{}{"result": "error", "description": "create folder failed"})
+			return
+		}
+		for _, v := range d.files {
+			if v.parentId == r.FormValue("parentId") && v.name == r.FormValue("name") && v.folder {
+				writeJson(w, map[string]interface{}{"result": "error", "description": "folder already exists"})
+				return
+			}
+		}
+		id := d.add(&fakeFile{parentId: r.FormValue("parentId"), name: r.FormValue("name"), folder: true})
+		writeJson(w, map[string]interface{}{"result": "ok", "data": map[string]interface{}{"id": id}})
+	case p == "/drive/user/files/create":
+		d.serveCreate(w, r)
+	case p == "/drive/user/files" && r.Method == "POST":
+		d.serveCommit(w, r)
+	case strings.HasPrefix(p, "/drive/user/files/") && strings.HasSuffix(p, "/del"):
+		delete(d.files, strings.TrimSuffix(strings.TrimPrefix(p, "/drive/user/files/"), "/del"))
+		writeJson(w, map[string]interface{}{"result": "ok"})
+	case strings.HasPrefix(p, "/drive/user/files/"):
+		id := strings.TrimPrefix(p, "/drive/user/files/")
+		f, ok := d.files[id]
+		if !ok {
+			writeJson(w, map[string]interface{}{"result": "error", "code": 404, "description": "file not exist"})
+			return
+		}
+		data := f.json()
+		if !f.folder {
+			data["storage"] = map[string]interface{}{
+				"jsonpUrl":    d.srv.URL + "/jsonp/" + id,
+				"downloadUrl": d.srv.URL + "/storage/" + id,
+			}
+		}
+		writeJson(w, map[string]interface{}{"result": "ok", "data": data})
+	case strings.HasPrefix(p, "/jsonp/"):
+		id := strings.TrimPrefix(p, "/jsonp/")
+		_, _ = fmt.Fprintf(w, `callback({"url":"%s/storage/%s","meta":"meta-%s"})`, d.srv.URL, id, id)
+	case strings.HasPrefix(p, "/storage/"):
+		if d.storageStatus != 0 {
+			w.WriteHeader(d.storageStatus)
+			_, _ = w.Write([]byte("<html>internal error</html>"))
+			return
+		}
+		f, ok := d.files[strings.TrimPrefix(p, "/storage/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, f.name, time.Time{}, bytes.NewReader(f.data))
+	case p == "/node/upload_block_chunk":
+		d.blockUrls = append(d.blockUrls, r.URL.RequestURI())
+		body, _ := ioutil.ReadAll(r.Body)
+		if d.failBlock != nil && d.failBlock(r.URL.Query().Get("block_meta")) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sum := fmt.Sprintf("%x", sha1.Sum(body))
+		d.blocks[sum] = body
+		writeJson(w, map[string]interface{}{"stat": "BLOCK_COMPLETED", "commit_meta": "cm-" + sum})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+//创建文件，云盘已有相同sha1的文件时返回exists，否则返回每个分片是否已存在
+func (d *fakeDrive) serveCreate(w http.ResponseWriter, r *http.Request) {
+	content := gjson.Get(r.FormValue("data"), "content")
+	sha1Hex := content.Get("storage.sha1").String()
+	d.seq++
+	uploadId := "up-" + strconv.Itoa(d.seq)
+	upload := &fakeUpload{name: content.Get("name").String(), sha1: sha1Hex}
+	d.uploads[uploadId] = upload
+	for _, v := range d.files {
+		if !v.folder && fmt.Sprintf("%x", sha1.Sum(v.data)) == sha1Hex {
+			writeJson(w, map[string]interface{}{"result": "ok", "data": map[string]interface{}{
+				"storage": map[string]interface{}{"exists": true, "uploadId": uploadId},
+			}})
+			return
+		}
+	}
+	blockInfos := content.Get("storage.kss.block_infos").Array()
+	if len(blockInfos) == 0 {
+		writeJson(w, map[string]interface{}{"result": "ok", "data": map[string]interface{}{
+			"storage": map[string]interface{}{"exists": false, "uploadId": uploadId},
+		}})
+		return
+	}
+	blockMetas := make([]interface{}, 0, len(blockInfos))
+	for k, v := range blockInfos {
+		blockSha1 := v.Get("sha1").String()
+		upload.blocks = append(upload.blocks, blockSha1)
+		existed := 0
+		if _, ok := d.blocks[blockSha1]; ok {
+			existed = 1
+		}
+		blockMetas = append(blockMetas, map[string]interface{}{
+			"block_meta":  fmt.Sprintf("bm-%d", k),
+			"commit_meta": "cm-" + blockSha1,
+			"is_existed":  existed,
+		})
+	}
+	nodeUrls := d.nodeUrls
+	if nodeUrls == nil {
+		nodeUrls = []string{d.srv.URL + "/node"}
+	}
+	writeJson(w, map[string]interface{}{"result": "ok", "data": map[string]interface{}{
+		"storage": map[string]interface{}{
+			"exists":   false,
+			"uploadId": uploadId,
+			"kss": map[string]interface{}{
+				"node_urls":   nodeUrls,
+				"file_meta":   "fm-" + uploadId,
+				"block_metas": blockMetas,
+			},
+		},
+	}})
+}
+
+//提交文件，按commit_metas拼接分片内容
+func (d *fakeDrive) serveCommit(w http.ResponseWriter, r *http.Request) {
+	content := gjson.Get(r.FormValue("data"), "content")
+	upload, ok := d.uploads[content.Get("storage.uploadId").String()]
+	if !ok {
+		writeJson(w, map[string]interface{}{"result": "error", "description": "upload not exist"})
+		return
+	}
+	var data []byte
+	if content.Get("storage.exists").Bool() {
+		for _, v := range d.files {
+			if !v.folder && fmt.Sprintf("%x", sha1.Sum(v.data)) == upload.sha1 {
+				data = v.data
+				break
+			}
+		}
+	} else {
+		for _, v := range content.Get("storage.kss.commit_metas").Array() {
+			block, ok := d.blocks[strings.TrimPrefix(v.Get("commit_meta").String(), "cm-")]
+			if !ok {
+				writeJson(w, map[string]interface{}{"result": "error", "description": "block not uploaded"})
+				return
+			}
+			data = append(data, block...)
+		}
+	}
+	if fmt.Sprintf("%x", sha1.Sum(data)) != upload.sha1 {
+		writeJson(w, map[string]interface{}{"result": "error", "description": "sha1 mismatch"})
+		return
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	id := d.add(&fakeFile{parentId: r.FormValue("parentId"), name: content.Get("name").String(), data: data})
+	writeJson(w, map[string]interface{}{"result": "ok", "data": map[string]interface{}{"id": id}})
+}
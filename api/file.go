@@ -1,9 +1,10 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/tidwall/gjson"
@@ -11,12 +12,13 @@ import (
 	"hash"
 	"io"
 	"io/ioutil"
-	"math"
 	"net/http"
 	"net/url"
 	"os"
-	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -25,304 +27,705 @@ const (
 	CreateFile  = BaseUri + "/drive/user/files/create"
 	UploadFile  = BaseUri + "/drive/user/files"
 	DeleteFiles = BaseUri + "/drive/user/files/%s/del"
+	RenameFile  = BaseUri + "/drive/user/files/%s/rename"
+	MoveFile    = BaseUri + "/drive/user/files/%s/move"
+	CopyFile    = BaseUri + "/drive/user/files/%s/copy"
 )
 
+//默认分片大小，可通过WithChunkSize修改
 const ChunkSize = 4194304
 
+const maxRedirects = 5
+
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) " +
+	"Chrome/79.0.3945.88 Safari/537.36"
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryDelay    = 500 * time.Millisecond
+	defaultUploadWorkers = 4
+	defaultMaxFileSize   = 4 * 1024 * 1024 * 1024
+)
+
+//Api的所有方法都可以在多个goroutine中并发调用
+//缓存、限流器和user的登录状态、userId、serviceToken都有锁保护，Option只应在NewApi时设置
 type Api interface {
 	GetFolder(string) ([]*File, error)
+	GetFolderContext(context.Context, string) ([]*File, error)
+	GetFolderByPath(string) (*File, error)
+	GetFolderFiltered(string, Kind) ([]*File, error)
+	GetRoot() (*File, error)
+	GetFolderTree(string, int) (*TreeNode, error)
+	RecentFiles(int) ([]*File, error)
 	GetFile(string) ([]byte, error)
+	GetFileInfo(string) (*File, error)
+	Exists(string) (bool, error)
+	GetFileByPath(string) ([]byte, error)
+	GetFileByPathTo(string, io.Writer) (int64, error)
+	GetFileContext(context.Context, string) ([]byte, error)
+	GetFileTo(string, io.Writer) (int64, error)
+	OpenFile(string) (io.ReadSeekCloser, error)
+	GetFileWithProgress(string, io.Writer, func(downloaded, total int64)) (int64, error)
+	GetFileVerified(string) ([]byte, error)
+	GetFileRange(string, int64, int64) ([]byte, error)
+	GetThumbnail(string, ThumbnailSize) ([]byte, error)
+	DownloadFileResumable(string, string) error
+	ResumeDownload(string) error
+	DownloadParallel(string, string, int) error
+	DownloadBlockVerified(string, string) error
+	DownloadFolder(string, string) error
+	DownloadFolderWithOptions(string, string, FolderOptions) (*SyncPlan, error)
+	DownloadFileContext(context.Context, string, string) error
 	GetFileDownLoadUrl(string) (string, error)
+	GetFileDownLoadUrlContext(context.Context, string) (string, error)
+	GetFileDownLoadURLWithExpiry(string) (string, time.Time, error)
+	GetStreamURL(string) (string, error)
 	UploadFile(string, string) (string, error)
+	UploadFileResult(string, string) (*UploadResult, error)
+	UploadFileContext(context.Context, string, string) (string, error)
+	UploadFileWithProgress(string, string, func(uploaded, total int64)) (string, error)
+	UploadFileWithOptions(string, string, UploadOptions) (string, error)
+	UploadFileWithHash(string, string, string) (string, error)
+	UploadFileWithSession(string, string, func(*UploadSession) error) (string, error)
+	ResumeUpload(*UploadSession, func(*UploadSession) error) (string, error)
+	PrepareUpload(string, string) (*UploadPlan, error)
+	CompleteUpload(*UploadPlan, []string) (string, error)
+	UploadReader(io.Reader, string, int64, string) (string, error)
+	UploadReaderWithProgress(io.Reader, string, int64, string, func(uploaded, total int64)) (string, error)
+	UploadBytes([]byte, string, string) (string, error)
+	UploadFiles([]string, string, int) ([]UploadResult, []error)
+	UploadFilesContext(context.Context, []string, string, int) ([]UploadResult, []error)
+	UploadFolder(string, string) error
+	UploadFolderWithOptions(string, string, FolderOptions) (*SyncPlan, error)
+	ExportManifest(string) ([]byte, error)
+	ImportManifest([]byte, string, string) (*SyncPlan, error)
+	DeleteFile(string) error
+	DeleteFileContext(context.Context, string) error
+	DeleteFiles([]string) error
+	DeleteFolderRecursive(string) error
+	CreateFolder(string, string) (string, error)
+	Rename(string, string) error
+	GetQuota() (int64, int64, error)
+	Search(string) ([]*File, error)
+	SearchWithOptions(string, SearchOptions) ([]*File, error)
+	CreateShareLink(string, ShareOptions) (string, error)
+	RevokeShareLink(string) error
+	Move(string, string) error
+	InvalidateCache(string)
+	TempDir() string
+	Close() error
+	RawGetFolder(string) (gjson.Result, error)
+	RawGetFileInfo(string) (gjson.Result, error)
+	ListTrash() ([]*File, error)
+	RestoreFromTrash(string) error
+	EmptyTrash() error
+	Copy(string, string) (string, error)
+	DeleteFilesContext(context.Context, []string) error
 }
 
 type api struct {
-	user *user.User
+	user           *user.User
+	maxFolderPages int
+	retryAttempts  int
+	retryDelay     time.Duration
+	uploadWorkers  int
+	maxFileSize    int64
+	client         *http.Client
+	quotaCheck     bool
+	limiter        *rateLimiter
+	logger         Logger
+	requestTimeout time.Duration
+	chunkSize      int64
+	cache          *metaCache
+	baseUri        string
+	preserveMtime  bool
+	userAgent      string
+	headers        http.Header
+	strictDedup    bool
+	tempDir        string
+	onBlock        func(BlockEvent)
+	byteLimiter    *rateLimiter
 }
 
-var FileApi = NewApi(user.Account)
+var (
+	defaultApi Api
+	apiOnce    sync.Once
+)
+
+// Deprecated: 使用NewApi(user)显式创建Api，FileApi仅为兼容保留，首次调用时才创建
+func FileApi() Api {
+	apiOnce.Do(func() {
+		defaultApi = NewApi(user.Account())
+	})
+	return defaultApi
+}
 
-func NewApi(user *user.User) Api {
-	return &api{
-		user: user,
+func NewApi(user *user.User, opts ...Option) Api {
+	api := &api{
+		user:          user,
+		retryAttempts: defaultRetryAttempts,
+		retryDelay:    defaultRetryDelay,
+		uploadWorkers: defaultUploadWorkers,
+		maxFileSize:   defaultMaxFileSize,
+		chunkSize:     ChunkSize,
+		baseUri:       BaseUri,
+		userAgent:     defaultUserAgent,
+		headers:       make(http.Header),
+		logger:        nopLogger{},
 	}
+	for _, opt := range opts {
+		opt(api)
+	}
+	return api
+}
+
+//使用自定义的http.Client发送所有请求，client需要携带用户登录后的cookies
+func NewApiWithClient(user *user.User, client *http.Client, opts ...Option) Api {
+	return NewApi(user, append([]Option{WithHttpClient(client)}, opts...)...)
 }
 
 //获取文件公开下载链接
 func (api *api) GetFileDownLoadUrl(id string) (string, error) {
-	var apiUrl = strings.Trim(fmt.Sprintf(GetFiles, id), "?jsonpCallback=callback")
-	resp, err := api.user.HttpClient.Get(apiUrl)
+	return api.GetFileDownLoadUrlContext(context.Background(), id)
+}
+
+func (api *api) GetFileDownLoadUrlContext(ctx context.Context, id string) (string, error) {
+	var apiUrl = strings.TrimSuffix(api.endpoint(GetFiles, id), "?jsonpCallback=callback")
+	all, err := api.get(ctx, apiUrl)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("get file %s failed, error: %w", id, err)
 	}
-	all, err := ioutil.ReadAll(resp.Body)
+	if result := gjson.GetBytes(all, "result").String(); result != "ok" {
+		return "", fmt.Errorf("get file %s failed, error: %w", id, newServerError(gjson.ParseBytes(all)))
+	}
+	downloadUrl := gjson.GetBytes(all, "data.storage.downloadUrl").String()
+	if downloadUrl == "" {
+		return "", fmt.Errorf("file %s has no download url: %w", id, ErrNotDownloadable)
+	}
+	return downloadUrl, nil
+}
+
+//获取可直接交给媒体播放器的地址，地址支持Range请求，不会强制作为附件下载
+//先尝试存储地址，不支持Range时尝试下载地址，都不支持时返回ErrNotAvailable
+func (api *api) GetStreamURL(id string) (string, error) {
+	ctx := context.Background()
+	storage, err := api.getFileStorage(ctx, id)
 	if err != nil {
 		return "", err
 	}
-	return gjson.Get(string(all), "data.storage.downloadUrl").String(), nil
+	var candidates []string
+	if u, err := url.Parse(storage.url); err == nil && storage.url != "" {
+		query := u.Query()
+		query.Set("meta", storage.meta)
+		u.RawQuery = query.Encode()
+		candidates = append(candidates, u.String())
+	}
+	if downloadUrl, err := api.GetFileDownLoadUrlContext(ctx, id); err == nil && downloadUrl != "" {
+		candidates = append(candidates, downloadUrl)
+	}
+	for _, v := range candidates {
+		if streamUrl, ok := api.checkRange(ctx, v); ok {
+			return streamUrl, nil
+		}
+	}
+	return "", fmt.Errorf("file %s has no url supporting range requests: %w", id, ErrNotAvailable)
 }
 
-//获取文件
-func (api *api) GetFile(id string) ([]byte, error) {
-	result, err := api.get(fmt.Sprintf(GetFiles, id))
+//HEAD请求检查地址是否支持Range，返回跟随重定向后的地址
+func (api *api) checkRange(ctx context.Context, rawUrl string) (string, bool) {
+	for hops := 0; hops <= maxRedirects; hops++ {
+		request, err := http.NewRequestWithContext(ctx, "HEAD", rawUrl, nil)
+		if err != nil {
+			return "", false
+		}
+		resp, err := api.do(request)
+		if err != nil {
+			api.logger.Debugf("check range of %s failed, error: %s", request.URL.Path, err)
+			return "", false
+		}
+		resp.Body.Close()
+		if !isRedirect(resp.StatusCode) {
+			return rawUrl, resp.StatusCode == http.StatusOK && resp.Header.Get("Accept-Ranges") == "bytes"
+		}
+		location, err := resp.Location()
+		if err != nil {
+			return "", false
+		}
+		rawUrl = location.String()
+	}
+	return "", false
+}
+
+//获取文件下载链接及链接的过期时间，无法从链接中解析过期时间时expiresAt为零值
+func (api *api) GetFileDownLoadURLWithExpiry(id string) (downloadUrl string, expiresAt time.Time, err error) {
+	downloadUrl, err = api.GetFileDownLoadUrl(id)
 	if err != nil {
-		return nil, err
+		return "", time.Time{}, err
 	}
-	realUrlStr := gjson.Get(string(result), "data.storage.jsonpUrl").String()
-	if realUrlStr == "" {
-		return nil, errors.New("get fileUrl failed")
+	return downloadUrl, parseUrlExpiry(downloadUrl), nil
+}
+
+//从签名链接的参数中解析过期时间，参数值为秒或毫秒时间戳
+func parseUrlExpiry(rawUrl string) time.Time {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return time.Time{}
+	}
+	query := u.Query()
+	for _, key := range []string{"Expires", "expires", "expire", "e"} {
+		ts, err := strconv.ParseInt(query.Get(key), 10, 64)
+		if err != nil || ts <= 0 {
+			continue
+		}
+		//毫秒时间戳
+		if ts > 1e12 {
+			return msToTime(ts)
+		}
+		return time.Unix(ts, 0)
+	}
+	return time.Time{}
+}
+
+//删除文件，文件会移入回收站，可通过RestoreFromTrash恢复
+func (api *api) DeleteFile(id string) error {
+	return api.DeleteFileContext(context.Background(), id)
+}
+
+func (api *api) DeleteFileContext(ctx context.Context, id string) error {
+	_, err := api.call(ctx, api.endpoint(DeleteFiles, id), url.Values{})
+	api.cache.invalidate(id)
+	return err
+}
+
+//重命名文件
+func (api *api) Rename(id string, newName string) error {
+	if newName == "" {
+		return errors.New("name can not be empty")
+	}
+	if strings.ContainsAny(newName, "/\\") {
+		return errors.New("name can not contain path separator")
 	}
-	result, err = api.get(realUrlStr)
+	_, err := api.call(context.Background(), api.endpoint(RenameFile, id), url.Values{
+		"name": []string{newName},
+	})
+	api.cache.invalidate(id)
+	return err
+}
+
+//移动文件到其他目录，目标目录与当前目录相同时不做任何操作
+func (api *api) Move(id string, newParentId string) error {
+	ctx := context.Background()
+	metadata, err := api.get(ctx, api.endpoint(GetFiles, id))
+	if err != nil {
+		return err
+	}
+	if gjson.GetBytes(metadata, "data.parentId").String() == newParentId {
+		return nil
+	}
+	_, err = api.call(ctx, api.endpoint(MoveFile, id), url.Values{
+		"parentId": []string{newParentId},
+	})
+	api.cache.invalidate(id)
+	api.cache.invalidate(newParentId)
+	return err
+}
+
+//在云盘中复制文件到其他目录，返回新文件id，复制到同一目录时由服务端自动重命名
+func (api *api) Copy(id string, newParentId string) (string, error) {
+	result, err := api.call(context.Background(), api.endpoint(CopyFile, id), url.Values{
+		"parentId": []string{newParentId},
+	})
+	api.cache.invalidate(newParentId)
+	if err != nil {
+		return "", fmt.Errorf("copy file failed, error: %w", err)
+	}
+	return result.Get("data.id").String(), nil
+}
+
+//批量删除文件，文件会移入回收站
+func (api *api) DeleteFiles(ids []string) error {
+	return api.DeleteFilesContext(context.Background(), ids)
+}
+
+func (api *api) DeleteFilesContext(ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		if err := api.DeleteFileContext(ctx, id); err != nil {
+			return fmt.Errorf("delete file %s failed, error: %w", id, err)
+		}
+	}
+	return nil
+}
+
+//判断文件或目录是否存在，不存在时返回false，error只用于网络或登录等问题
+func (api *api) Exists(id string) (bool, error) {
+	_, err := api.rawGet(api.endpoint(GetFiles, id))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//获取文件信息
+func (api *api) GetFileInfo(id string) (*File, error) {
+	if file, ok := api.cache.getFile(id); ok {
+		return file, nil
+	}
+	result, err := api.get(context.Background(), api.endpoint(GetFiles, id))
 	if err != nil {
 		return nil, err
 	}
-	realUrl := gjson.Parse(strings.Trim(string(result), "callback()"))
+	msg := gjson.ParseBytes(result)
+	if msg.Get("result").String() != "ok" {
+		return nil, fmt.Errorf("get file info failed, error: %w", newServerError(msg))
+	}
+	file := newFile(msg.Get("data"))
+	api.cache.setFile(file)
+	return file, nil
+}
 
-	resp, err := api.user.HttpClient.PostForm(
-		realUrl.Get("url").String(),
-		url.Values{"meta": []string{realUrl.Get("meta").String()}})
+//获取文件
+func (api *api) GetFile(id string) ([]byte, error) {
+	return api.GetFileContext(context.Background(), id)
+}
+
+func (api *api) GetFileContext(ctx context.Context, id string) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := api.getFileTo(ctx, id, &buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//下载文件并写入w，返回写入的字节数
+func (api *api) GetFileTo(id string, w io.Writer) (int64, error) {
+	return api.getFileTo(context.Background(), id, w, nil)
+}
+
+//打开文件内容用于流式读取，第一次Read时才发起下载请求，读取完成后需要调用Close释放连接
+//支持Seek，Seek后从新的偏移用Range请求重新下载
+func (api *api) OpenFile(id string) (io.ReadSeekCloser, error) {
+	ctx := context.Background()
+	storage, err := api.getFileStorage(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	all, err := ioutil.ReadAll(resp.Body)
-	return all, err
+	return &remoteReader{api: api, ctx: ctx, storage: storage}, nil
 }
 
-//上传文件
-func (api *api) UploadFile(filePath string, parentId string) (string, error) {
-	fileInfo, err := os.Stat(filePath)
+//下载文件并写入w，下载过程中回调已下载大小和文件总大小，完成时再回调一次
+func (api *api) GetFileWithProgress(id string, w io.Writer, onProgress func(downloaded, total int64)) (int64, error) {
+	return api.getFileTo(context.Background(), id, w, onProgress)
+}
+
+func (api *api) getFileTo(ctx context.Context, id string, w io.Writer, onProgress func(downloaded, total int64)) (int64, error) {
+	storage, err := api.getFileStorage(ctx, id)
 	if err != nil {
-		return "", err
+		return 0, err
+	}
+	resp, err := api.openFileStorage(ctx, storage, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return 0, err
 	}
-	fileName := path.Base(filePath)
-	if fileInfo.Size() == 0 || fileInfo.Size() >= 4*1024*1024*1024 {
-		return "", errors.New("can not upload empty file or file big than 4GB")
+	var reader io.Reader = resp.Body
+	total := resp.ContentLength
+	if total < 0 {
+		total = storage.size
 	}
-	fileSize := fileInfo.Size()
-	fileSha1 := calFileHash(filePath, "sha1")
+	if onProgress != nil {
+		reader = NewProgressReader(resp.Body, total, onProgress)
+	}
+	n, err := io.Copy(w, reader)
+	if err != nil {
+		api.logger.Errorf("download file %s failed after %d bytes, error: %s", id, n, err)
+		return n, err
+	}
+	api.logger.Debugf("download file %s completed, %d bytes", id, n)
+	if onProgress != nil {
+		onProgress(n, total)
+	}
+	return n, nil
+}
 
-	var blockInfos []BlockInfo
-	//大于4MB需要分片
-	if fileSize > ChunkSize {
-		blockInfos, err = api.getFileBlocks(fileInfo, filePath)
-		if err != nil {
-			return "", errors.New("get file blocks failed")
-		}
-	} else {
-		blockInfos = []BlockInfo{
-			{
-				Blob: struct {
-				}{},
-				Sha1: fileSha1,
-				Md5:  calFileHash(filePath, "md5"),
-				Size: fileSize,
-			},
-		}
+//获取文件的实际下载地址
+//文件不存在时返回ErrNotFound，id为目录或没有下载地址时返回ErrNotDownloadable
+func (api *api) getFileStorage(ctx context.Context, id string) (*fileStorage, error) {
+	metadata, err := api.get(ctx, api.endpoint(GetFiles, id))
+	if err != nil {
+		return nil, fmt.Errorf("get file %s failed, error: %w", id, err)
 	}
-	var uploadJson = UploadJson{
-		Content: UploadContent{
-			Name: fileName,
-			Storage: UploadStorage{
-				Size: fileSize,
-				Sha1: fileSha1,
-				Kss: UploadKss{
-					BlockInfos: blockInfos,
-				},
-			},
-		},
-	}
-	data, _ := json.Marshal(uploadJson)
-	//创建分片
-	resp, err := api.user.HttpClient.PostForm(CreateFile, url.Values{
-		"data":         []string{string(data)},
-		"serviceToken": []string{api.user.ServiceToken},
-	})
+	if result := gjson.GetBytes(metadata, "result"); result.Exists() && result.String() != "ok" {
+		return nil, fmt.Errorf("get file %s failed, error: %w", id, newServerError(gjson.ParseBytes(metadata)))
+	}
+	data := gjson.GetBytes(metadata, "data")
+	if !data.Exists() {
+		return nil, fmt.Errorf("file %s: %w", id, ErrNotFound)
+	}
+	if data.Get("type").String() == "folder" {
+		return nil, fmt.Errorf("file %s is a folder: %w", id, ErrNotDownloadable)
+	}
+	realUrlStr := data.Get("storage.jsonpUrl").String()
+	if realUrlStr == "" {
+		return nil, fmt.Errorf("file %s has no download url: %w", id, ErrNotDownloadable)
+	}
+	result, err := api.get(ctx, realUrlStr)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	all, _ := ioutil.ReadAll(resp.Body)
-	if result := gjson.Get(string(all), "result").String(); result != "ok" {
-		return "", errors.New("create file failed, error: " + gjson.Get(string(all), "description").String())
-	}
-	isExisted := gjson.Get(string(all), "data.storage.exists").Bool()
-	//云盘已有此文件
-	if isExisted {
-		data := UploadJson{Content: UploadContent{
-			Name: fileName,
-			Storage: UploadExistedStorage{
-				UploadId: gjson.Get(string(all), "data.storage.uploadId").String(),
-				Exists:   true,
-			},
-		}}
-		return api.createFile(parentId, data)
-	} else {
-		//云盘不存在该文件
-		kss := gjson.Get(string(all), "data.storage.kss")
-		var (
-			nodeUrls   = kss.Get("node_urls").Array()
-			fileMeta   = kss.Get("file_meta").String()
-			blockMetas = kss.Get("block_metas").Array()
-		)
-		apiNode := nodeUrls[0].String()
-		if apiNode == "" {
-			return "", errors.New("no available url node")
-		}
-		//上传分片
-		var commitMetas []map[string]string
-		for k, block := range blockMetas {
-			commitMeta, err := api.uploadBlock(k, apiNode, fileMeta, filePath, block)
-			if err != nil {
-				panic(err)
-				return "", err
-			}
-			commitMetas = append(commitMetas, commitMeta)
-		}
-		//最终完成上传
-		data := UploadJson{Content: UploadContent{
-			Name: fileName,
-			Storage: UploadStorage{
-				Size: fileSize,
-				Sha1: fileSha1,
-				Kss: Kss{
-					Stat:            "OK",
-					NodeUrls:        nodeUrls,
-					SecureKey:       kss.Get("secure_key").String(),
-					ContentCacheKey: kss.Get("contentCacheKey").String(),
-					FileMeta:        kss.Get("file_meta").String(),
-					CommitMetas:     commitMetas,
-				},
-				UploadId: gjson.Get(string(all), "data.storage.uploadId").String(),
-				Exists:   false,
-			},
-		}}
-		return api.createFile(parentId, data)
-	}
-}
-
-//获取文件分片信息
-func (api *api) getFileBlocks(fileInfo os.FileInfo, filePath string) ([]BlockInfo, error) {
-	num := int(math.Ceil(float64(fileInfo.Size()) / float64(ChunkSize)))
-	file, err := os.OpenFile(filePath, os.O_RDONLY, os.ModePerm)
+	realUrl := gjson.Parse(strings.Trim(string(result), "callback()"))
+	sha1 := data.Get("storage.sha1").String()
+	if sha1 == "" {
+		sha1 = data.Get("sha1").String()
+	}
+	var blocks []BlockInfo
+	blockInfos := data.Get("storage.kss.block_infos")
+	if !blockInfos.Exists() {
+		blockInfos = data.Get("storage.blockInfos")
+	}
+	for _, v := range blockInfos.Array() {
+		blocks = append(blocks, BlockInfo{
+			Sha1: v.Get("sha1").String(),
+			Md5:  v.Get("md5").String(),
+			Size: v.Get("size").Int(),
+		})
+	}
+	return &fileStorage{
+		url:     realUrl.Get("url").String(),
+		meta:    realUrl.Get("meta").String(),
+		size:    data.Get("size").Int(),
+		sha1:    sha1,
+		modTime: msToTime(data.Get("modifyTime").Int()),
+		blocks:  blocks,
+	}, nil
+}
+
+//请求文件内容，offset大于0时从offset处开始下载
+func (api *api) openFileStorage(ctx context.Context, storage *fileStorage, offset int64) (*http.Response, error) {
+	if offset > 0 {
+		return api.openFileRange(ctx, storage, offset, -1)
+	}
+	return api.openFileRange(ctx, storage, -1, -1)
+}
+
+//请求文件[start, end]范围的内容，start小于0表示请求整个文件，end小于0表示到文件末尾
+func (api *api) openFileRange(ctx context.Context, storage *fileStorage, start, end int64) (*http.Response, error) {
+	form := url.Values{"meta": []string{storage.meta}}
+	request, err := http.NewRequestWithContext(ctx, "POST", storage.url, strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
-	var i int64 = 1
-	var blockInfos []BlockInfo
-	for b := make([]byte, ChunkSize); i <= int64(num); i++ {
-		offset := (i - 1) * ChunkSize
-		_, _ = file.Seek(offset, 0)
-		if len(b) > int(fileInfo.Size()-offset) {
-			b = make([]byte, fileInfo.Size()-offset)
-		}
-		_, err := file.Read(b)
-		if err != nil {
-			continue
-		}
-		blockInfo := BlockInfo{
-			Blob: struct{}{},
-			Sha1: calHash(strings.NewReader(string(b)), "sha1"),
-			Md5:  calHash(strings.NewReader(string(b)), "md5"),
-			Size: int64(len(b)),
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if start >= 0 && end >= 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	} else if start >= 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	}
+	resp, err := api.do(request)
+	if err != nil {
+		return nil, err
+	}
+	if api.byteLimiter != nil {
+		resp.Body = &throttledBody{
+			ReadCloser: resp.Body,
+			reader:     &throttledReader{ctx: ctx, reader: resp.Body, limiter: api.byteLimiter},
 		}
-		blockInfos = append(blockInfos, blockInfo)
 	}
-	return blockInfos, nil
+	return resp, nil
 }
 
-//上传文件分片
-func (api *api) uploadBlock(num int, apiNode string, fileMeta string, filePath string, block interface{}) (map[string]string, error) {
-	m, ok := (block).(gjson.Result)
-	if !ok {
-		return nil, errors.New("block info error")
+//GET请求并读取响应内容，非2xx时返回StatusError，网络错误或5xx时按WithRetry的设置重试，4xx不重试
+func (api *api) get(ctx context.Context, url string) (body []byte, err error) {
+	err = api.retry(ctx, func() error {
+		body, err = api.getOnce(ctx, url)
+		return err
+	})
+	return body, err
+}
+
+func (api *api) getOnce(ctx context.Context, url string) ([]byte, error) {
+	result, err := api.doGet(ctx, url)
+	if err != nil {
+		return nil, err
 	}
-	//block已存在则不上传
-	if m.Get("is_existed").Int() == 1 {
-		return map[string]string{"commit_meta": m.Get("commit_meta").String()}, nil
-	} else {
-		uploadUrl := apiNode + "/upload_block_chunk?chunk_pos=0&file_meta=" + fileMeta + "&block_meta=" + m.Get("block_meta").String()
-		file, _ := os.Open(filePath)
-		fileInfo, _ := file.Stat()
-
-		offset := int64(num * ChunkSize)
-		chunkSize := ChunkSize
-		if chunkSize > int(fileInfo.Size()-offset) {
-			chunkSize = int(fileInfo.Size() - offset)
+	//手动跟随重定向，最多maxRedirects次
+	for hops := 0; isRedirect(result.StatusCode); hops++ {
+		closeBody(result)
+		if hops >= maxRedirects {
+			return nil, fmt.Errorf("stopped after %d redirects", maxRedirects)
 		}
-		fileBlock := make([]byte, chunkSize)
-		_, err := file.Seek(offset, 0)
-		_, err = file.Read(fileBlock)
+		location, err := result.Location()
 		if err != nil {
 			return nil, err
 		}
-		request, _ := http.NewRequest("POST", uploadUrl, strings.NewReader(string(fileBlock)))
-		request.Header.Set("DNT", "1")
-		request.Header.Set("Origin", "https://i.mi.com")
-		request.Header.Set("Referer", "https://i.mi.com/drive")
-		request.Header.Set("Content-Type", "application/octet-stream")
-		response, err := api.user.HttpClient.Do(request)
+		result, err = api.doGet(ctx, location.String())
 		if err != nil {
 			return nil, err
 		}
-		readAll, err := ioutil.ReadAll(response.Body)
-		stat := gjson.Get(string(readAll), "stat").String()
-		if stat != "BLOCK_COMPLETED" {
-			return nil, errors.New("block not completed")
+	}
+	defer result.Body.Close()
+	bytes, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		return nil, err
+	}
+	if result.StatusCode < http.StatusOK || result.StatusCode >= http.StatusMultipleChoices {
+		return nil, newStatusError(result, bytes)
+	}
+	return bytes, nil
+}
+
+//WithTempDir设置的临时目录，未设置时为空，表示使用os.TempDir()
+func (api *api) TempDir() string {
+	return api.tempDir
+}
+
+//关闭空闲连接并清空缓存，关闭后仍可继续使用，会重新建立连接
+func (api *api) Close() error {
+	api.httpClient().CloseIdleConnections()
+	api.cache.clear()
+	return nil
+}
+
+//将以BaseUri开头的接口地址替换为api.baseUri，并用args格式化
+func (api *api) endpoint(uri string, args ...interface{}) string {
+	if api.baseUri != BaseUri {
+		uri = api.baseUri + strings.TrimPrefix(uri, BaseUri)
+	}
+	if len(args) == 0 {
+		return uri
+	}
+	return fmt.Sprintf(uri, args...)
+}
+
+func (api *api) httpClient() *http.Client {
+	if api.client != nil {
+		return api.client
+	}
+	return api.user.HttpClient
+}
+
+//设置所有请求共用的请求头，请求中已设置的不覆盖
+func (api *api) setHeaders(request *http.Request) {
+	var headers = http.Header{
+		"User-Agent": []string{api.userAgent},
+		"Dnt":        []string{"1"},
+		"Origin":     []string{api.baseUri},
+		"Referer":    []string{api.baseUri + "/drive"},
+	}
+	for k, v := range api.headers {
+		headers[k] = v
+	}
+	for k, v := range headers {
+		if _, ok := request.Header[k]; !ok && len(v) > 0 {
+			request.Header[k] = v
 		}
-		response.Body.Close()
-		return map[string]string{"commit_meta": gjson.Get(string(readAll), "commit_meta").String()}, nil
 	}
 }
 
-//最终创建文件
-func (api *api) createFile(parentId string, data interface{}) (string, error) {
-	dataJson, err := json.Marshal(data)
-	if err != nil {
-		return "", err
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
 	}
-	form := url.Values{}
-	form.Add("data", string(dataJson))
-	form.Add("serviceToken", api.user.ServiceToken)
-	form.Add("parentId", parentId)
-	request, _ := http.NewRequest("POST", UploadFile, strings.NewReader(form.Encode()))
-	request.Header.Set("DNT", "1")
-	request.Header.Set("Origin", "https://i.mi.com")
-	request.Header.Set("Referer", "https://i.mi.com/drive")
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	response, err := api.user.HttpClient.Do(request)
-	if err != nil {
-		return "", err
+	return false
+}
+
+func (api *api) do(request *http.Request) (*http.Response, error) {
+	api.setHeaders(request)
+	if api.limiter != nil {
+		if err := api.limiter.wait(request.Context(), 1); err != nil {
+			return nil, err
+		}
+	}
+	client := api.httpClient()
+	if api.requestTimeout <= 0 {
+		return client.Do(request)
 	}
-	defer response.Body.Close()
-	readAll, err := ioutil.ReadAll(response.Body)
+	//超时时间包含读取响应内容，与request中ctx的截止时间取较早者
+	ctx, cancel := context.WithTimeout(request.Context(), api.requestTimeout)
+	response, err := client.Do(request.WithContext(ctx))
 	if err != nil {
-		return "", err
+		cancel()
+		return nil, err
 	}
-	if result := gjson.Get(string(readAll), "result").String(); result != "ok" {
-		return "", errors.New(gjson.Get(string(readAll), "description").String())
-	} else {
-		id := gjson.Get(string(readAll), "data.id").String()
-		return id, nil
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+//响应不是2xx时返回包含部分响应内容的StatusError，不关闭响应
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return nil
 	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, statusErrorBodySize))
+	return newStatusError(resp, body)
+}
+
+//读取剩余内容后关闭响应，连接才能被复用，内容较多时直接关闭
+func closeBody(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
 }
 
-func (api *api) get(url string) ([]byte, error) {
-	result, err := api.user.HttpClient.Get(url)
+//关闭响应时释放超时ctx
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+func (api *api) doGet(ctx context.Context, apiUrl string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", apiUrl, nil)
 	if err != nil {
 		return nil, err
 	}
-	if result.StatusCode == http.StatusFound {
-		result, err = api.user.HttpClient.Get(result.Header.Get("Location"))
-		if err != nil {
-			return nil, err
-		}
-	}
-	bytes, err := ioutil.ReadAll(result.Body)
+	return api.do(request)
+}
+
+func (api *api) postForm(ctx context.Context, apiUrl string, form url.Values) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, "POST", apiUrl, strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
-	defer result.Body.Close()
-	return bytes, nil
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return api.do(request)
+}
+
+//携带serviceToken提交表单，result不为ok时返回对应的错误
+func (api *api) call(ctx context.Context, apiUrl string, form url.Values) (result gjson.Result, err error) {
+	err = api.withAuth(func() error {
+		result, err = api.callOnce(ctx, apiUrl, form)
+		return err
+	})
+	return result, err
+}
+
+func (api *api) callOnce(ctx context.Context, apiUrl string, form url.Values) (gjson.Result, error) {
+	form.Set("serviceToken", api.user.Token())
+	resp, err := api.postForm(ctx, apiUrl, form)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	defer resp.Body.Close()
+	all, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	result := gjson.ParseBytes(all)
+	if result.Get("result").String() != "ok" {
+		return result, newServerError(result)
+	}
+	return result, nil
 }
 
 func calFileHash(filePath string, tp string) string {
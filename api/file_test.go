@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-micloud/user"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetFileDownLoadUrl(t *testing.T) {
+	d := newFakeDrive(t)
+	id := d.addFile(RootFolderId, "a.txt", []byte("hello"))
+	folderId := d.addFolder(RootFolderId, "dir")
+	api := d.api()
+	downloadUrl, err := api.GetFileDownLoadUrl(id)
+	if err != nil || downloadUrl != d.srv.URL+"/storage/"+id {
+		t.Fatalf("GetFileDownLoadUrl() = %q, %v", downloadUrl, err)
+	}
+	if _, err := api.GetFileDownLoadUrl(folderId); !errors.Is(err, ErrNotDownloadable) {
+		t.Fatalf("GetFileDownLoadUrl(folder) error = %v, want ErrNotDownloadable", err)
+	}
+	var apiErr *ApiError
+	if _, err := api.GetFileDownLoadUrl("missing"); !errors.As(err, &apiErr) {
+		t.Fatalf("GetFileDownLoadUrl(missing) error = %v, want ApiError", err)
+	}
+}
+
+//id以"?jsonpCallback=callback"中的字符结尾时不能被截掉
+func TestGetFileDownLoadUrlKeepsId(t *testing.T) {
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.RequestURI())
+		_, _ = w.Write([]byte(`{"result":"ok","data":{"storage":{"downloadUrl":"https://example.com/d"}}}`))
+	}))
+	defer srv.Close()
+	api := NewApi(user.NewUser(), WithBaseUri(srv.URL))
+	for _, id := range []string{"abc", "k", "callback", "123k"} {
+		requested = nil
+		if _, err := api.GetFileDownLoadUrl(id); err != nil {
+			t.Fatalf("GetFileDownLoadUrl(%q) error = %v", id, err)
+		}
+		if want := "/drive/user/files/" + id; len(requested) != 1 || requested[0] != want {
+			t.Fatalf("GetFileDownLoadUrl(%q) requested %v, want %s", id, requested, want)
+		}
+	}
+}
+
+func TestGetFollowsRedirects(t *testing.T) {
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		case "/c":
+			_, _ = w.Write([]byte("done"))
+		default:
+			http.Redirect(w, r, r.URL.Path+"x", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	api := NewApi(user.NewUser(), WithBaseUri(srv.URL), WithRetry(1, 0)).(*api)
+	body, err := api.get(context.Background(), srv.URL+"/a")
+	if err != nil || string(body) != "done" {
+		t.Fatalf("get() = %q, %v", body, err)
+	}
+	if want := []string{"/a", "/b", "/c"}; fmt.Sprint(requested) != fmt.Sprint(want) {
+		t.Fatalf("requested %v, want %v", requested, want)
+	}
+	requested = nil
+	if _, err := api.get(context.Background(), srv.URL+"/loop"); err == nil {
+		t.Fatal("get() followed redirects without limit")
+	}
+	if len(requested) != maxRedirects+1 {
+		t.Fatalf("requested %d urls, want %d", len(requested), maxRedirects+1)
+	}
+}
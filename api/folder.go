@@ -1,10 +1,13 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/tidwall/gjson"
+	"net/url"
+	"sort"
+	"sync"
 )
 
 const (
@@ -13,26 +16,273 @@ const (
 	DeleteFolder = BaseUri + "/drive/user/folders/%s/delete"
 )
 
+//根目录的id，上传到根目录时parentId传RootFolderId
+const RootFolderId = "0"
+
 var ErrorNotLogin = errors.New("未登录")
 
-// 获取目录下的文件
+// 获取目录下的文件，按服务端顺序拼接所有分页
 func (api *api) GetFolder(id string) ([]*File, error) {
-	apiUrl := fmt.Sprintf(GetFolders, id)
-	result, err := api.get(apiUrl)
+	return api.GetFolderContext(context.Background(), id)
+}
+
+func (api *api) GetFolderContext(ctx context.Context, id string) ([]*File, error) {
+	if files, ok := api.cache.getFolder(id); ok {
+		return files, nil
+	}
+	var (
+		files     = make([]*File, 0)
+		pageToken string
+	)
+	for page := 1; ; page++ {
+		apiUrl := api.endpoint(GetFolders, id)
+		if pageToken != "" {
+			apiUrl += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+		result, err := api.get(ctx, apiUrl)
+		if err != nil {
+			return nil, err
+		}
+		msg := gjson.ParseBytes(result)
+		if msg.Get("result").String() != "ok" {
+			if msg.Get("R").Int() == 401 {
+				return nil, ErrorNotLogin
+			}
+			return nil, fmt.Errorf("get folders failed, error: %w", newServerError(msg))
+		}
+		for _, v := range msg.Get("data.list").Array() {
+			files = append(files, newFile(v))
+		}
+		nextToken := msg.Get("data.cursor").String()
+		if nextToken == "" {
+			nextToken = msg.Get("data.pageToken").String()
+		}
+		if nextToken == "" || nextToken == pageToken {
+			break
+		}
+		if api.maxFolderPages > 0 && page >= api.maxFolderPages {
+			break
+		}
+		pageToken = nextToken
+	}
+	api.cache.setFolder(id, files)
+	return files, nil
+}
+
+//获取目录下指定类型的文件
+func (api *api) GetFolderFiltered(id string, kind Kind) ([]*File, error) {
+	files, err := api.GetFolderContext(context.Background(), id)
+	if err != nil || kind == AnyKind {
+		return files, err
+	}
+	var filtered = make([]*File, 0, len(files))
+	for _, v := range files {
+		if v.IsFolder() == (kind == FolderKind) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered, nil
+}
+
+//获取根目录，根目录id固定为RootFolderId，无需请求接口
+func (api *api) GetRoot() (*File, error) {
+	return &File{Id: RootFolderId, Name: "/", Type: "folder"}, nil
+}
+
+//创建目录，返回新目录id；父目录下已有同名目录时返回已有目录的id和ErrAlreadyExists
+func (api *api) CreateFolder(name string, parentId string) (string, error) {
+	ctx := context.Background()
+	id, err := api.findFolder(ctx, name, parentId)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	if id != "" {
+		return id, ErrAlreadyExists
 	}
-	msg := &Msg{}
-	err = json.Unmarshal(result, msg)
+	result, err := api.call(ctx, api.endpoint(CreateFolder), url.Values{
+		"name":     []string{name},
+		"parentId": []string{parentId},
+	})
+	api.cache.invalidate(parentId)
 	if err != nil {
-		return nil, err
+		//检查之后其他客户端创建了同名目录，重新获取其id
+		if errors.Is(err, ErrAlreadyExists) {
+			if id, findErr := api.findFolder(ctx, name, parentId); findErr == nil && id != "" {
+				return id, ErrAlreadyExists
+			}
+		}
+		return "", fmt.Errorf("create folder failed, error: %w", err)
+	}
+	if id = result.Get("data.id").String(); id == "" {
+		return "", errors.New("create folder succeeded but no id returned")
+	}
+	return id, nil
+}
+
+//返回父目录下同名目录的id，不存在时返回空
+func (api *api) findFolder(ctx context.Context, name string, parentId string) (string, error) {
+	files, err := api.GetFolderContext(ctx, parentId)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range files {
+		if v.Name == name && v.IsFolder() {
+			return v.Id, nil
+		}
+	}
+	return "", nil
+}
+
+//云盘没有最近文件的接口，从根目录遍历所有目录，按修改时间从新到旧返回最多limit个文件
+//limit小于等于0时返回所有文件
+func (api *api) RecentFiles(limit int) ([]*File, error) {
+	ctx := context.Background()
+	var (
+		recent  []*File
+		folders = []string{RootFolderId}
+	)
+	newestFirst := func() {
+		sort.SliceStable(recent, func(i, j int) bool {
+			return recent[i].ModifyTime.After(recent[j].ModifyTime)
+		})
 	}
-	if msg.Result == "ok" {
-		return msg.Data.List, nil
+	for len(folders) > 0 {
+		id := folders[0]
+		folders = folders[1:]
+		files, err := api.GetFolderContext(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range files {
+			if v.IsFolder() {
+				folders = append(folders, v.Id)
+			} else {
+				recent = append(recent, v)
+			}
+		}
+		//只保留最新的limit个文件，避免文件很多时占用过多内存
+		if limit > 0 && len(recent) > 2*limit {
+			newestFirst()
+			recent = recent[:limit]
+		}
+	}
+	newestFirst()
+	if limit > 0 && len(recent) > limit {
+		recent = recent[:limit]
+	}
+	return recent, nil
+}
+
+//深度优先删除目录下的所有文件和子目录，最后删除目录本身
+//部分删除失败时继续删除其余文件，以MultiError返回失败的id，包含失败项的目录不会被删除
+func (api *api) DeleteFolderRecursive(id string) error {
+	if id == RootFolderId {
+		return errors.New("can not delete root folder")
+	}
+	failed := make(map[string]error)
+	api.deleteFolder(context.Background(), id, failed)
+	if len(failed) > 0 {
+		return &MultiError{Failed: failed}
+	}
+	return nil
+}
+
+//返回是否删除成功，失败的id记录在failed中
+func (api *api) deleteFolder(ctx context.Context, id string, failed map[string]error) bool {
+	files, err := api.GetFolderContext(ctx, id)
+	if err != nil {
+		failed[id] = err
+		return false
+	}
+	ok := true
+	for _, v := range files {
+		if v.IsFolder() {
+			ok = api.deleteFolder(ctx, v.Id, failed) && ok
+			continue
+		}
+		if err := api.DeleteFileContext(ctx, v.Id); err != nil {
+			failed[v.Id] = err
+			ok = false
+		}
+	}
+	if !ok {
+		return false
+	}
+	_, err = api.call(ctx, api.endpoint(DeleteFolder, id), url.Values{})
+	api.cache.invalidate(id)
+	if err != nil {
+		failed[id] = err
+		return false
+	}
+	return true
+}
+
+//GetFolderTree同时获取目录列表的最大请求数
+const folderTreeWorkers = 4
+
+//目录树的节点，文件的Children为空
+type TreeNode struct {
+	File     *File
+	Children []*TreeNode
+}
+
+//获取以rootId为根的目录树，maxDepth为展开的目录层数，1表示只包含rootId下的文件，小于等于0表示不限制
+//各目录的列表并发获取，任一目录获取失败时返回错误
+func (api *api) GetFolderTree(rootId string, maxDepth int) (*TreeNode, error) {
+	var (
+		root *File
+		err  error
+	)
+	if rootId == RootFolderId {
+		root, err = api.GetRoot()
 	} else {
-		if gjson.Get(string(result), "R").Int() == 401 {
-			return nil, ErrorNotLogin
+		root, err = api.GetFileInfo(rootId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !root.IsFolder() {
+		return nil, fmt.Errorf("%s is not a folder", rootId)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, folderTreeWorkers)
+	)
+	var expand func(node *TreeNode, depth int)
+	expand = func(node *TreeNode, depth int) {
+		defer wg.Done()
+		sem <- struct{}{}
+		files, err := api.GetFolderContext(ctx, node.File.Id)
+		<-sem
+		if err != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+			mu.Unlock()
+			return
 		}
-		return nil, errors.New("get folders failed")
+		node.Children = make([]*TreeNode, 0, len(files))
+		for _, v := range files {
+			child := &TreeNode{File: v}
+			node.Children = append(node.Children, child)
+			if v.IsFolder() && (maxDepth <= 0 || depth < maxDepth) {
+				wg.Add(1)
+				go expand(child, depth+1)
+			}
+		}
+	}
+	tree := &TreeNode{File: root}
+	wg.Add(1)
+	expand(tree, 1)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
 	}
+	return tree, nil
 }
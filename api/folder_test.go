@@ -0,0 +1,50 @@
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//缓存的目录列表过期前其他客户端创建了同名目录，服务端报告已存在时返回已有目录的id
+func TestCreateFolderExistsOnServer(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api(WithCacheTTL(time.Minute))
+	if _, err := api.GetFolder(RootFolderId); err != nil {
+		t.Fatal(err)
+	}
+	existing := d.addFolder(RootFolderId, "dir")
+	id, err := api.CreateFolder("dir", RootFolderId)
+	if !errors.Is(err, ErrAlreadyExists) || id != existing {
+		t.Fatalf("CreateFolder() = %q, %v, want %q, ErrAlreadyExists", id, err, existing)
+	}
+}
+
+func TestUploadFolderIntoExistingFolder(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api(WithCacheTTL(time.Minute))
+	localDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(localDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(localDir, "sub", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.GetFolder(RootFolderId); err != nil {
+		t.Fatal(err)
+	}
+	existing := d.addFolder(RootFolderId, "sub")
+	if err := api.UploadFolder(localDir, RootFolderId); err != nil {
+		t.Fatalf("UploadFolder() error = %v", err)
+	}
+	files, err := api.GetFolder(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "a.txt" {
+		t.Fatalf("GetFolder(%s) = %+v, want a.txt uploaded into the existing folder", existing, files)
+	}
+}
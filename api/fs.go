@@ -0,0 +1,220 @@
+package api
+
+import (
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//将云盘作为只读的fs.FS，路径以根目录为起点，如 Doc/a.txt
+type DriveFS struct {
+	api     Api
+	mu      sync.Mutex
+	folders map[string][]*File
+}
+
+var (
+	_ fs.FS        = (*DriveFS)(nil)
+	_ fs.ReadDirFS = (*DriveFS)(nil)
+	_ fs.StatFS    = (*DriveFS)(nil)
+)
+
+func NewDriveFS(api Api) *DriveFS {
+	return &DriveFS{
+		api:     api,
+		folders: make(map[string][]*File),
+	}
+}
+
+func (d *DriveFS) Open(name string) (fs.File, error) {
+	file, err := d.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if file.IsFolder() {
+		files, err := d.list(file.Id)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &driveDir{info: fileInfo{file}, entries: dirEntries(files)}, nil
+	}
+	reader, err := d.api.OpenFile(file.Id)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &driveFile{info: fileInfo{file}, reader: reader}, nil
+}
+
+func (d *DriveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := d.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !file.IsFolder() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	files, err := d.list(file.Id)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return dirEntries(files), nil
+}
+
+func (d *DriveFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := d.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{file}, nil
+}
+
+//从根目录逐级查找路径对应的文件
+func (d *DriveFS) resolve(op, name string) (*File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	file := &File{Id: RootFolderId, Name: ".", Type: "folder"}
+	if name == "." {
+		return file, nil
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if !file.IsFolder() {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		files, err := d.list(file.Id)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		var found *File
+		for _, v := range files {
+			if v.Name == segment {
+				found = v
+				break
+			}
+		}
+		if found == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		file = found
+	}
+	return file, nil
+}
+
+//获取目录下的文件，结果会被缓存
+func (d *DriveFS) list(id string) ([]*File, error) {
+	d.mu.Lock()
+	files, ok := d.folders[id]
+	d.mu.Unlock()
+	if ok {
+		return files, nil
+	}
+	files, err := d.api.GetFolder(id)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.folders[id] = files
+	d.mu.Unlock()
+	return files, nil
+}
+
+func dirEntries(files []*File) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(files))
+	for _, v := range files {
+		entries = append(entries, fs.FileInfoToDirEntry(fileInfo{v}))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+type fileInfo struct {
+	file *File
+}
+
+func (f fileInfo) Name() string {
+	return f.file.Name
+}
+
+func (f fileInfo) Size() int64 {
+	return f.file.Size
+}
+
+func (f fileInfo) Mode() fs.FileMode {
+	if f.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (f fileInfo) ModTime() time.Time {
+	return f.file.ModifyTime
+}
+
+func (f fileInfo) IsDir() bool {
+	return f.file.IsFolder()
+}
+
+func (f fileInfo) Sys() interface{} {
+	return f.file
+}
+
+type driveFile struct {
+	info   fileInfo
+	reader io.ReadSeekCloser
+}
+
+func (f *driveFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *driveFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+//每次Seek后的读取都会重新发起Range请求
+func (f *driveFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *driveFile) Close() error {
+	return f.reader.Close()
+}
+
+type driveDir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *driveDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *driveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *driveDir) Close() error {
+	return nil
+}
+
+func (d *driveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remain := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remain, nil
+	}
+	if len(remain) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remain) {
+		n = len(remain)
+	}
+	d.offset += n
+	return remain[:n], nil
+}
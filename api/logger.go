@@ -0,0 +1,14 @@
+package api
+
+//日志接口，zap的SugaredLogger可直接使用
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+//默认不输出任何日志
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+
+func (nopLogger) Errorf(format string, args ...interface{}) {}
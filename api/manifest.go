@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//目录结构清单，可保存为json，通过ImportManifest在云盘中重建
+type Manifest struct {
+	RootId  string          `json:"root_id"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+//清单中的文件或目录，Path为相对于根目录的路径，以/分隔，父目录总在子项之前
+type ManifestEntry struct {
+	Path       string    `json:"path"`
+	Id         string    `json:"id"`
+	Type       string    `json:"type"`
+	Size       int64     `json:"size"`
+	Sha1       string    `json:"sha1,omitempty"`
+	ModifyTime time.Time `json:"modify_time"`
+}
+
+//导出rootId下完整的目录结构，包括每个文件的路径、id、大小、sha1和修改时间
+func (api *api) ExportManifest(rootId string) ([]byte, error) {
+	tree, err := api.GetFolderTree(rootId, 0)
+	if err != nil {
+		return nil, err
+	}
+	manifest := Manifest{RootId: rootId, Entries: make([]ManifestEntry, 0)}
+	var walk func(node *TreeNode, dir string)
+	walk = func(node *TreeNode, dir string) {
+		for _, v := range node.Children {
+			p := path.Join(dir, v.File.Name)
+			manifest.Entries = append(manifest.Entries, ManifestEntry{
+				Path:       p,
+				Id:         v.File.Id,
+				Type:       v.File.Type,
+				Size:       v.File.Size,
+				Sha1:       v.File.Sha1,
+				ModifyTime: v.File.ModifyTime,
+			})
+			if v.File.IsFolder() {
+				walk(v, p)
+			}
+		}
+	}
+	walk(tree, "")
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+//按清单在parentId下重建目录结构，文件从localDir中对应的路径上传
+//云盘中已有sha1相同的文件会跳过，中断后再次调用即可继续；本地文件与清单中的sha1不一致时不上传
+//单个文件失败不会中断，结束后以MultiError返回所有失败的路径
+func (api *api) ImportManifest(data []byte, localDir string, parentId string) (*SyncPlan, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	var (
+		ctx       = context.Background()
+		folderIds = map[string]string{"": parentId}
+		//创建失败的目录，之后的条目不再重试
+		folderErrs = make(map[string]error)
+		remotes    = make(map[string][]*File)
+		failed     = make(map[string]error)
+		plan       = &SyncPlan{}
+	)
+	//按路径获取目录id，不存在时逐级创建
+	var ensureFolder func(dir string) (string, error)
+	ensureFolder = func(dir string) (string, error) {
+		if id, ok := folderIds[dir]; ok {
+			return id, nil
+		}
+		if err, ok := folderErrs[dir]; ok {
+			return "", err
+		}
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		pid, err := ensureFolder(parent)
+		if err != nil {
+			folderErrs[dir] = err
+			return "", err
+		}
+		//已存在的目录使用其id，无法获取id时按失败处理
+		id, err := api.CreateFolder(path.Base(dir), pid)
+		if errors.Is(err, ErrAlreadyExists) && id != "" {
+			err = nil
+		}
+		if err != nil {
+			folderErrs[dir] = err
+			return "", err
+		}
+		folderIds[dir] = id
+		return id, nil
+	}
+	for _, entry := range manifest.Entries {
+		p := path.Clean(entry.Path)
+		if p == "." || p == ".." || path.IsAbs(p) || strings.HasPrefix(p, "../") {
+			failed[entry.Path] = fmt.Errorf("invalid path %s", entry.Path)
+			continue
+		}
+		if entry.Type == "folder" {
+			if _, err := ensureFolder(p); err != nil {
+				failed[p] = err
+			}
+			continue
+		}
+		dir := path.Dir(p)
+		if dir == "." {
+			dir = ""
+		}
+		folderId, err := ensureFolder(dir)
+		if err != nil {
+			failed[p] = err
+			continue
+		}
+		localPath := filepath.Join(localDir, filepath.FromSlash(p))
+		if _, err := os.Stat(localPath); err != nil {
+			failed[p] = err
+			continue
+		}
+		localSha1 := calFileHash(localPath, "sha1")
+		if entry.Sha1 != "" && localSha1 != entry.Sha1 {
+			failed[p] = fmt.Errorf("local file %s differs from manifest: %w", localPath, ErrSha1Mismatch)
+			continue
+		}
+		//每个目录只获取一次列表，清单中的路径不重复，上传后无需刷新
+		files, ok := remotes[folderId]
+		if !ok {
+			if files, err = api.GetFolderContext(ctx, folderId); err != nil {
+				failed[p] = err
+				continue
+			}
+			remotes[folderId] = files
+		}
+		var existed, same bool
+		for _, v := range files {
+			if v.Name == path.Base(p) && v.Type == "file" {
+				existed = true
+				same = v.Sha1 == localSha1
+			}
+		}
+		if same {
+			plan.Skip = append(plan.Skip, p)
+			continue
+		}
+		uploadOpts := UploadOptions{Sha1: localSha1}
+		if existed {
+			uploadOpts.OnConflict = OnConflictOverwrite
+			plan.Overwrite = append(plan.Overwrite, p)
+		} else {
+			plan.Create = append(plan.Create, p)
+		}
+		if _, err := api.uploadFile(ctx, localPath, folderId, uploadOpts, nil, nil); err != nil {
+			failed[p] = err
+		}
+	}
+	if len(failed) > 0 {
+		return plan, &MultiError{Failed: failed}
+	}
+	return plan, nil
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImportManifestIntoExistingFolder(t *testing.T) {
+	d := newFakeDrive(t)
+	dirId := d.addFolder(RootFolderId, "dir")
+	d.addFile(dirId, "a.txt", []byte("a"))
+	api := d.api(WithCacheTTL(time.Minute))
+	data, err := api.ExportManifest(RootFolderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(localDir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(localDir, "dir", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	//目录列表已缓存，之后在云盘中创建的目录只能由服务端发现
+	targetId := d.addFolder(RootFolderId, "target")
+	if _, err := api.GetFolder(targetId); err != nil {
+		t.Fatal(err)
+	}
+	existing := d.addFolder(targetId, "dir")
+	if _, err := api.ImportManifest(data, localDir, targetId); err != nil {
+		t.Fatalf("ImportManifest() error = %v", err)
+	}
+	files, err := api.GetFolder(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "a.txt" {
+		t.Fatalf("GetFolder(%s) = %+v, want a.txt imported into the existing folder", existing, files)
+	}
+}
+
+//每个目录只获取一次列表，创建失败的目录不重复创建
+func TestImportManifestRequests(t *testing.T) {
+	d := newFakeDrive(t)
+	localDir := t.TempDir()
+	manifest := Manifest{RootId: RootFolderId}
+	for _, dir := range []string{"dir", "bad"} {
+		if err := os.Mkdir(filepath.Join(localDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 30; i++ {
+			name := fmt.Sprintf("%s/%d.txt", dir, i)
+			if err := ioutil.WriteFile(filepath.Join(localDir, filepath.FromSlash(name)), []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+			manifest.Entries = append(manifest.Entries, ManifestEntry{Path: name, Type: "file"})
+		}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.failFolder = "bad"
+	api := d.api(WithRetry(1, 0), WithCacheTTL(time.Minute))
+	_, err = api.ImportManifest(data, localDir, RootFolderId)
+	var multiErr *MultiError
+	if !errors.As(err, &multiErr) || len(multiErr.Failed) != 30 {
+		t.Fatalf("ImportManifest() error = %v, want 30 failures under bad", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var dirId string
+	for _, v := range d.files {
+		if v.folder && v.name == "dir" {
+			dirId = v.id
+		}
+	}
+	if n := d.listings[dirId]; n > 2 {
+		t.Fatalf("listed dir %d times for 30 files", n)
+	}
+	if d.folderCreates != 2 {
+		t.Fatalf("sent %d create folder requests, want 2", d.folderCreates)
+	}
+	if n := len(d.files); n != 32 {
+		t.Fatalf("%d files on drive, want root, dir and 30 files", n)
+	}
+}
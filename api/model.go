@@ -1,24 +1,76 @@
 package api
 
+import (
+	"github.com/tidwall/gjson"
+	"time"
+)
+
+//云盘中的文件或目录，CreateTime和ModifyTime由服务端的毫秒时间戳转换为本地时区的时间
 type File struct {
-	Sha1       string
-	ModifyTime uint
-	Size       int64
-	CreateTime uint
-	Name       string
 	Id         string
+	Name       string
 	Type       string
+	Size       int64
+	Sha1       string
+	ParentId   string
+	CreateTime time.Time
+	ModifyTime time.Time
 	Revision   string
 }
 
-type Msg struct {
-	Result    string
-	Retryable bool
-	Code      int
-	Data      struct {
-		HasMore bool
-		List    []*File
+//是否为目录，Type为folder表示目录，file表示文件
+func (f *File) IsFolder() bool {
+	return f.Type == "folder"
+}
+
+//文件类型，用于筛选目录下的文件
+type Kind int
+
+const (
+	//文件和目录
+	AnyKind Kind = iota
+	//只有目录
+	FolderKind
+	//只有文件
+	FileKind
+)
+
+//解析接口返回的文件信息
+func newFile(r gjson.Result) *File {
+	sha1 := r.Get("sha1").String()
+	if sha1 == "" {
+		sha1 = r.Get("storage.sha1").String()
+	}
+	return &File{
+		Id:         r.Get("id").String(),
+		Name:       r.Get("name").String(),
+		Type:       r.Get("type").String(),
+		Size:       r.Get("size").Int(),
+		Sha1:       sha1,
+		ParentId:   r.Get("parentId").String(),
+		CreateTime: msToTime(r.Get("createTime").Int()),
+		ModifyTime: msToTime(r.Get("modifyTime").Int()),
+		Revision:   r.Get("revision").String(),
+	}
+}
+
+//毫秒时间戳转换为time.Time，0表示未知时间
+func msToTime(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
 	}
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond))
+}
+
+//文件下载信息
+type fileStorage struct {
+	url     string
+	meta    string
+	size    int64
+	sha1    string
+	modTime time.Time
+	//每个分片的sha1和大小，元数据中没有分片信息时为空
+	blocks []BlockInfo
 }
 
 type UploadJson struct {
@@ -26,9 +78,72 @@ type UploadJson struct {
 }
 
 type UploadContent struct {
-	Name    string      `json:"name"`
-	Storage interface{} `json:"storage"`
+	Name     string      `json:"name"`
+	MimeType string      `json:"mimeType,omitempty"`
+	Storage  interface{} `json:"storage"`
+}
+
+//上传时目录下已有同名文件的处理方式
+type ConflictPolicy int
+
+const (
+	//不做处理，直接上传
+	OnConflictNone ConflictPolicy = iota
+	//跳过上传，返回已有文件的id
+	OnConflictSkip
+	//删除已有文件后上传
+	OnConflictOverwrite
+	//在文件名后追加序号，如 a (1).txt
+	OnConflictRename
+)
+
+//上传设置
+type UploadOptions struct {
+	//文件类型，为空时根据扩展名或文件内容自动识别
+	MimeType string
+	//目录下已有同名文件时的处理方式
+	OnConflict ConflictPolicy
+	//整个文件的sha1，为空时自动计算
+	Sha1 string
+}
+
+//上传结果，Deduplicated表示云盘已有相同文件，无需上传数据（秒传）
+type UploadResult struct {
+	Id           string
+	Name         string
+	Size         int64
+	Sha1         string
+	Deduplicated bool
 }
+
+//分片上传完成的事件，Existed为true表示云盘已有该分片，没有实际上传
+type BlockEvent struct {
+	Name    string
+	Index   int
+	Total   int
+	Size    int64
+	Existed bool
+}
+
+//上传或下载整个目录的设置
+type FolderOptions struct {
+	//只计算需要处理的文件，不实际上传或下载
+	DryRun bool
+	//上传目录时的增量同步状态文件，记录每个文件上次同步时的大小、修改时间、sha1和上传到的目录
+	//上传到同一目录、大小和修改时间未变且云盘中同名文件的sha1与记录一致时直接跳过，不计算sha1，为空时不使用
+	StateFile string
+}
+
+//上传或下载整个目录时每个本地文件的处理结果
+type SyncPlan struct {
+	//对方不存在，需要新建的文件
+	Create []string
+	//已存在且sha1相同，跳过的文件
+	Skip []string
+	//已存在但内容不同，需要覆盖的文件
+	Overwrite []string
+}
+
 type UploadStorage struct {
 	Size     int64       `json:"size"`
 	Sha1     string      `json:"sha1"`
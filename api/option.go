@@ -0,0 +1,168 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+//Api配置项
+type Option func(*api)
+
+//GetFolder最多拉取的分页数，0表示不限制
+func WithMaxFolderPages(n int) Option {
+	return func(api *api) {
+		api.maxFolderPages = n
+	}
+}
+
+//分片上传及GET请求失败时的重试次数(包含首次)及首次重试的等待时间，之后每次等待时间翻倍，attempts<=1表示不重试
+func WithRetry(attempts int, delay time.Duration) Option {
+	return func(api *api) {
+		api.retryAttempts = attempts
+		api.retryDelay = delay
+	}
+}
+
+//并发上传分片的协程数
+func WithUploadWorkers(n int) Option {
+	return func(api *api) {
+		api.uploadWorkers = n
+	}
+}
+
+//允许上传的最大文件大小，0表示不限制
+func WithMaxFileSize(size int64) Option {
+	return func(api *api) {
+		api.maxFileSize = size
+	}
+}
+
+//使用自定义的http.Client代替user.HttpClient，如设置代理、超时等
+func WithHttpClient(client *http.Client) Option {
+	return func(api *api) {
+		api.client = client
+	}
+}
+
+//上传前检查云盘剩余空间，空间不足时直接返回ErrQuotaExceeded
+func WithQuotaCheck(check bool) Option {
+	return func(api *api) {
+		api.quotaCheck = check
+	}
+}
+
+//限制每秒发出的请求数，burst为允许的突发请求数，默认不限制
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(api *api) {
+		if requestsPerSecond > 0 {
+			api.limiter = newRateLimiter(requestsPerSecond, burst)
+		} else {
+			api.limiter = nil
+		}
+	}
+}
+
+//设置日志，默认不输出日志
+func WithLogger(logger Logger) Option {
+	return func(api *api) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		api.logger = logger
+	}
+}
+
+//每个请求的超时时间(包含读取响应内容)，与传入ctx的截止时间取较早者，0表示不限制
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(api *api) {
+		api.requestTimeout = timeout
+	}
+}
+
+//上传分片大小，默认ChunkSize(4MB)，size<=0时保持默认值，服务端可能不接受非4MB的分片
+func WithChunkSize(size int64) Option {
+	return func(api *api) {
+		if size > 0 {
+			api.chunkSize = size
+		}
+	}
+}
+
+//缓存目录列表及文件信息的时间，0表示不缓存，上传、删除等操作会自动清除相关缓存
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(api *api) {
+		if ttl > 0 {
+			api.cache = newMetaCache(ttl)
+		} else {
+			api.cache = nil
+		}
+	}
+}
+
+//接口地址，默认BaseUri，可用于测试服务器或其他地区的服务
+func WithBaseUri(baseUri string) Option {
+	return func(api *api) {
+		if baseUri != "" {
+			api.baseUri = strings.TrimSuffix(baseUri, "/")
+		}
+	}
+}
+
+//下载到本地时将文件修改时间设置为云盘中的修改时间，默认使用下载时间
+func WithPreserveModTime(preserve bool) Option {
+	return func(api *api) {
+		api.preserveMtime = preserve
+	}
+}
+
+//请求使用的User-Agent，默认为Chrome浏览器的User-Agent
+func WithUserAgent(userAgent string) Option {
+	return func(api *api) {
+		api.userAgent = userAgent
+	}
+}
+
+//所有请求附加的请求头，可多次设置，与默认请求头同名时覆盖默认值
+func WithHeader(key, value string) Option {
+	return func(api *api) {
+		api.headers.Set(key, value)
+	}
+}
+
+//严格校验，默认关闭
+//秒传后下载部分内容与本地比较，不一致时删除已创建的文件并返回ErrSha1Mismatch
+//上传时提供了sha1也会重新计算并比较
+func WithStrictDedup(strict bool) Option {
+	return func(api *api) {
+		api.strictDedup = strict
+	}
+}
+
+//UploadReader缓存不支持随机读取的数据及WebDAV接收上传内容时使用的临时目录，默认为os.TempDir()
+//临时文件在上传结束后删除，上传失败时也会删除
+func WithTempDir(dir string) Option {
+	return func(api *api) {
+		api.tempDir = dir
+	}
+}
+
+//每个分片上传完成或因云盘已存在而跳过时调用fn，可用于区分显示已上传和已存在的分片
+//fn在上传协程中依次调用，不应阻塞
+func WithBlockHook(fn func(BlockEvent)) Option {
+	return func(api *api) {
+		api.onBlock = fn
+	}
+}
+
+//限制上传分片和下载文件的总速度，所有并发的传输共享该限制，0表示不限制
+//与WithRateLimit不同，WithRateLimit只限制请求次数
+func WithMaxBytesPerSec(bytesPerSec int64) Option {
+	return func(api *api) {
+		if bytesPerSec > 0 {
+			api.byteLimiter = newRateLimiter(float64(bytesPerSec), int(bytesPerSec))
+		} else {
+			api.byteLimiter = nil
+		}
+	}
+}
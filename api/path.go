@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//根据路径获取目录，如 /Documents/2024，任一级不存在时返回ErrNotFound
+func (api *api) GetFolderByPath(p string) (*File, error) {
+	file, err := api.lookupPath(context.Background(), p)
+	if err != nil {
+		return nil, err
+	}
+	if !file.IsFolder() {
+		return nil, fmt.Errorf("%s is not a folder: %w", p, ErrNotFound)
+	}
+	return file, nil
+}
+
+//根据路径下载文件，如 /backups/db.sql
+func (api *api) GetFileByPath(p string) ([]byte, error) {
+	file, err := api.getFileByPath(context.Background(), p)
+	if err != nil {
+		return nil, err
+	}
+	return api.GetFileContext(context.Background(), file.Id)
+}
+
+//根据路径下载文件并写入w，返回写入的字节数
+func (api *api) GetFileByPathTo(p string, w io.Writer) (int64, error) {
+	ctx := context.Background()
+	file, err := api.getFileByPath(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	return api.getFileTo(ctx, file.Id, w, nil)
+}
+
+func (api *api) getFileByPath(ctx context.Context, p string) (*File, error) {
+	file, err := api.lookupPath(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if file.Type != "file" {
+		return nil, fmt.Errorf("%s is not a file: %w", p, ErrNotFound)
+	}
+	return file, nil
+}
+
+//从根目录逐级查找路径对应的文件或目录，空路径或/表示根目录
+func (api *api) lookupPath(ctx context.Context, p string) (*File, error) {
+	file, _ := api.GetRoot()
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !file.IsFolder() {
+			return nil, fmt.Errorf("%s is not a folder: %w", file.Name, ErrNotFound)
+		}
+		files, err := api.GetFolderContext(ctx, file.Id)
+		if err != nil {
+			return nil, err
+		}
+		var next *File
+		for _, v := range files {
+			if v.Name == name {
+				next = v
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s not found in %s: %w", name, p, ErrNotFound)
+		}
+		file = next
+	}
+	return file, nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"io"
+)
+
+//包装io.Reader，每次读取后回调累计读取的字节数，total为总大小，未知时传-1
+type ProgressReader struct {
+	reader     io.Reader
+	read       int64
+	total      int64
+	onProgress func(read, total int64)
+}
+
+func NewProgressReader(r io.Reader, total int64, onProgress func(read, total int64)) *ProgressReader {
+	return &ProgressReader{reader: r, total: total, onProgress: onProgress}
+}
+
+func (r *ProgressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		r.onProgress(r.read, r.total)
+	}
+	return n, err
+}
+
+//包装io.Writer，每次写入后回调累计写入的字节数，total为总大小，未知时传-1
+type ProgressWriter struct {
+	writer     io.Writer
+	written    int64
+	total      int64
+	onProgress func(written, total int64)
+}
+
+func NewProgressWriter(w io.Writer, total int64, onProgress func(written, total int64)) *ProgressWriter {
+	return &ProgressWriter{writer: w, total: total, onProgress: onProgress}
+}
+
+func (w *ProgressWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if n > 0 {
+		w.written += int64(n)
+		w.onProgress(w.written, w.total)
+	}
+	return n, err
+}
+
+//每次读取前检查ctx，ctx取消后返回ctx的错误
+type ctxReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/tidwall/gjson"
+)
+
+const Quota = BaseUri + "/status/lite/alldetail"
+
+//获取云盘已用空间和总空间，单位字节
+func (api *api) GetQuota() (used, total int64, err error) {
+	return api.getQuota(context.Background())
+}
+
+func (api *api) getQuota(ctx context.Context) (used, total int64, err error) {
+	result, err := api.get(ctx, api.endpoint(Quota))
+	if err != nil {
+		return 0, 0, err
+	}
+	msg := gjson.ParseBytes(result)
+	if msg.Get("result").String() != "ok" {
+		return 0, 0, fmt.Errorf("get quota failed, error: %w", newServerError(msg))
+	}
+	return msg.Get("data.used").Int(), msg.Get("data.total").Int(), nil
+}
+
+//上传前检查剩余空间是否足够
+func (api *api) checkQuota(ctx context.Context, size int64) error {
+	used, total, err := api.getQuota(ctx)
+	if err != nil {
+		return err
+	}
+	if total > 0 && used+size > total {
+		return fmt.Errorf("%w: need %d bytes, %d bytes available", ErrQuotaExceeded, size, total-used)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+//同一个Api并发列目录、上传和删除，需配合go test -race运行
+func TestApiConcurrentUse(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api(WithCacheTTL(time.Minute), WithUploadWorkers(2), WithChunkSize(16))
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 8; i++ {
+		filePath := filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		if err := ioutil.WriteFile(filePath, []byte(fmt.Sprintf("concurrent upload content %d", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			id, err := api.UploadFile(filePath, RootFolderId)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := api.DeleteFile(id); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				if _, err := api.GetFolder(RootFolderId); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	files, err := api.GetFolder(RootFolderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("GetFolder() returned %d files after deleting all uploads", len(files))
+	}
+}
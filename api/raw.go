@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+	"github.com/tidwall/gjson"
+)
+
+//获取目录列表接口返回的原始json(仅第一页)，用于读取File中没有的字段
+func (api *api) RawGetFolder(id string) (gjson.Result, error) {
+	return api.rawGet(api.endpoint(GetFolders, id))
+}
+
+//获取文件信息接口返回的原始json
+func (api *api) RawGetFileInfo(id string) (gjson.Result, error) {
+	return api.rawGet(api.endpoint(GetFiles, id))
+}
+
+//result不为ok时同时返回原始json和对应的错误
+func (api *api) rawGet(apiUrl string) (gjson.Result, error) {
+	body, err := api.get(context.Background(), apiUrl)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	result := gjson.ParseBytes(body)
+	if result.Get("result").String() != "ok" {
+		return result, newServerError(result)
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+//远程文件读取器，Seek只记录偏移，之后的Read从新偏移重新发起Range请求
+//偏移改变后的每次读取都是一次新请求，频繁Seek(尤其是向后Seek)代价较高
+type remoteReader struct {
+	api     *api
+	ctx     context.Context
+	storage *fileStorage
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (r *remoteReader) Read(p []byte) (int, error) {
+	if r.storage.size > 0 && r.offset >= r.storage.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		resp, err := r.api.openFileStorage(r.ctx, r.storage, r.offset)
+		if err != nil {
+			return 0, err
+		}
+		if err := checkStatus(resp); err != nil {
+			resp.Body.Close()
+			return 0, err
+		}
+		if r.offset > 0 && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return 0, errors.New("server does not support range requests")
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *remoteReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.storage.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *remoteReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
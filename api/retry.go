@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//Retry-After的最大等待时间，避免服务端返回过长的时间时长时间阻塞
+const maxRetryAfter = time.Minute
+
+//按指数退避重试fn，直到成功、次数用完或ctx被取消
+//服务端返回Retry-After时按其等待，等待时间都会加上随机抖动
+func (api *api) retry(ctx context.Context, fn func() error) error {
+	return api.retryIf(ctx, fn, retryable)
+}
+
+//只在服务端通过Retry-After要求稍后重试时重试，用于重复提交会产生副作用的请求
+func (api *api) retryThrottled(ctx context.Context, fn func() error) error {
+	return api.retryIf(ctx, fn, func(err error) bool {
+		return retryAfter(err) > 0
+	})
+}
+
+func (api *api) retryIf(ctx context.Context, fn func() error, shouldRetry func(error) bool) error {
+	var err error
+	for i := 0; i == 0 || i < api.retryAttempts; i++ {
+		if i > 0 {
+			delay := retryAfter(err)
+			if delay <= 0 {
+				delay = api.retryDelay << uint(i-1)
+			}
+			timer := time.NewTimer(withJitter(delay))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !shouldRetry(err) {
+			return err
+		}
+		api.logger.Errorf("attempt %d/%d failed, error: %s", i+1, api.retryAttempts, err)
+	}
+	return err
+}
+
+func retryable(err error) bool {
+	//登录过期重试也无法成功
+	if errors.Is(err, ErrAuthExpired) {
+		return false
+	}
+	//4xx重试也无法成功，429表示请求过多，可以稍后重试
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode < http.StatusInternalServerError &&
+		statusErr.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	return true
+}
+
+//返回错误中服务端要求的重试等待时间，没有时返回0
+func retryAfter(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+//解析Retry-After，值为秒数或http时间
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		delay = time.Until(t)
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return delay
+}
+
+//增加最多20%的随机等待，避免多个请求同时重试
+func withJitter(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return delay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+//是否为连接失败、超时等网络错误，服务端返回的错误状态码不算
+func isConnError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
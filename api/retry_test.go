@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"go-micloud/user"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+//GET请求在5xx和网络错误时重试，4xx不重试
+func TestGetRetry(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		n := calls[r.URL.Path]
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/5xx":
+			if n < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/conn":
+			if n < 2 {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					_ = conn.Close()
+				}
+				return
+			}
+		case "/400":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	api := NewApi(user.NewUser(), WithBaseUri(srv.URL), WithRetry(4, time.Millisecond)).(*api)
+	for _, v := range []struct {
+		path  string
+		calls int
+		ok    bool
+	}{
+		//第一个请求使用新连接，http.Transport不会自动重试
+		{"/conn", 2, true},
+		{"/5xx", 3, true},
+		{"/400", 1, false},
+		{"/404", 1, false},
+	} {
+		body, err := api.get(context.Background(), srv.URL+v.path)
+		if v.ok && (err != nil || string(body) != "ok") {
+			t.Errorf("get(%s) = %q, %v", v.path, body, err)
+		}
+		var statusErr *StatusError
+		if !v.ok && !errors.As(err, &statusErr) {
+			t.Errorf("get(%s) error = %v, want StatusError", v.path, err)
+		}
+		mu.Lock()
+		if calls[v.path] != v.calls {
+			t.Errorf("get(%s) sent %d requests, want %d", v.path, calls[v.path], v.calls)
+		}
+		mu.Unlock()
+	}
+}
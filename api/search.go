@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"net/url"
+	"strings"
+)
+
+const SearchFiles = BaseUri + "/drive/user/search?keyword=%s"
+
+//搜索条件
+type SearchOptions struct {
+	//file或folder，为空表示不限制
+	Type string
+	//最多返回的数量，0表示不限制
+	Limit int
+}
+
+//按文件名搜索整个云盘
+func (api *api) Search(keyword string) ([]*File, error) {
+	return api.SearchWithOptions(keyword, SearchOptions{})
+}
+
+//按文件名搜索整个云盘，并按类型和数量过滤结果
+func (api *api) SearchWithOptions(keyword string, opts SearchOptions) ([]*File, error) {
+	result, err := api.get(context.Background(), api.endpoint(SearchFiles, url.QueryEscape(keyword)))
+	if err != nil {
+		return nil, err
+	}
+	msg := gjson.ParseBytes(result)
+	if msg.Get("result").String() != "ok" {
+		return nil, fmt.Errorf("search failed, error: %w", newServerError(msg))
+	}
+	var (
+		files = make([]*File, 0)
+		lower = strings.ToLower(keyword)
+	)
+	for _, v := range msg.Get("data.list").Array() {
+		file := newFile(v)
+		if !strings.Contains(strings.ToLower(file.Name), lower) {
+			continue
+		}
+		if opts.Type != "" && file.Type != opts.Type {
+			continue
+		}
+		files = append(files, file)
+		if opts.Limit > 0 && len(files) >= opts.Limit {
+			break
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+//分片上传会话，可序列化为json保存，上传中断后通过ResumeUpload继续上传
+type UploadSession struct {
+	FilePath        string      `json:"file_path"`
+	ParentId        string      `json:"parent_id"`
+	Name            string      `json:"name"`
+	MimeType        string      `json:"mime_type"`
+	Size            int64       `json:"size"`
+	Sha1            string      `json:"sha1"`
+	BlockInfos      []BlockInfo `json:"block_infos"`
+	UploadId        string      `json:"upload_id"`
+	FileMeta        string      `json:"file_meta"`
+	NodeUrls        []string    `json:"node_urls"`
+	SecureKey       string      `json:"secure_key"`
+	ContentCacheKey string      `json:"content_cache_key"`
+	//接口返回的每个分片的block_meta原始json
+	BlockMetas []string `json:"block_metas"`
+	//已提交分片的commit_meta，未提交的分片为nil
+	CommitMetas []map[string]string `json:"commit_metas"`
+	//服务端要求的分片大小，未返回时为0
+	BlockSize int64 `json:"block_size,omitempty"`
+}
+
+//根据创建文件接口返回的data.storage更新会话，file_meta变化时之前提交的分片作废
+func (s *UploadSession) update(storage gjson.Result) {
+	kss := storage.Get("kss")
+	var (
+		fileMeta   = kss.Get("file_meta").String()
+		blockMetas = kss.Get("block_metas").Array()
+		nodeUrls   = kss.Get("node_urls").Array()
+	)
+	if fileMeta != s.FileMeta || len(s.CommitMetas) != len(blockMetas) {
+		s.CommitMetas = make([]map[string]string, len(blockMetas))
+	}
+	s.UploadId = storage.Get("uploadId").String()
+	s.FileMeta = fileMeta
+	s.SecureKey = kss.Get("secure_key").String()
+	s.ContentCacheKey = kss.Get("contentCacheKey").String()
+	s.BlockSize = kss.Get("block_size").Int()
+	s.NodeUrls = make([]string, 0, len(nodeUrls))
+	for _, v := range nodeUrls {
+		if v.String() != "" {
+			s.NodeUrls = append(s.NodeUrls, v.String())
+		}
+	}
+	s.BlockMetas = make([]string, 0, len(blockMetas))
+	for _, v := range blockMetas {
+		s.BlockMetas = append(s.BlockMetas, v.Raw)
+	}
+}
+
+//上传文件，创建文件后及每个分片提交后调用save保存会话，可用于中断后继续上传
+func (api *api) UploadFileWithSession(filePath string, parentId string, save func(*UploadSession) error) (string, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	return uploadId(api.uploadFile(context.Background(), absPath, parentId, UploadOptions{}, nil, save))
+}
+
+//继续未完成的上传，重新确认云盘已有的分片，只上传缺少的分片后提交
+func (api *api) ResumeUpload(session *UploadSession, save func(*UploadSession) error) (string, error) {
+	ctx := context.Background()
+	file, err := os.Open(session.FilePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fileInfo.Size() != session.Size {
+		return "", fmt.Errorf("file %s changed since upload started", session.FilePath)
+	}
+	storage, err := api.createStorage(ctx, session.Name, session.MimeType, session.Size, session.Sha1, session.BlockInfos)
+	if err != nil {
+		return "", err
+	}
+	if storage.Get("exists").Bool() {
+		return api.createExistedFile(ctx, session.ParentId, session.Name, session.MimeType, storage.Get("uploadId").String())
+	}
+	session.update(storage)
+	return api.uploadSession(ctx, file, session, nil, save)
+}
+
+//上传会话中未提交的分片并提交文件
+func (api *api) uploadSession(ctx context.Context, r io.ReaderAt, session *UploadSession,
+	onProgress func(uploaded, total int64), save func(*UploadSession) error) (string, error) {
+	if onProgress == nil {
+		onProgress = func(uploaded, total int64) {}
+	}
+	if len(session.NodeUrls) == 0 {
+		return "", ErrNoUploadNode
+	}
+	if save != nil {
+		if err := save(session); err != nil {
+			return "", err
+		}
+	}
+	//上传分片
+	if err := api.uploadBlocks(ctx, session.NodeUrls, r, session, onProgress, save); err != nil {
+		return "", err
+	}
+	return api.commitSession(ctx, session)
+}
+
+//所有分片上传后提交文件
+func (api *api) commitSession(ctx context.Context, session *UploadSession) (string, error) {
+	data := UploadJson{Content: UploadContent{
+		Name:     session.Name,
+		MimeType: session.MimeType,
+		Storage: UploadStorage{
+			Size: session.Size,
+			Sha1: session.Sha1,
+			Kss: Kss{
+				Stat:            "OK",
+				NodeUrls:        session.NodeUrls,
+				SecureKey:       session.SecureKey,
+				ContentCacheKey: session.ContentCacheKey,
+				FileMeta:        session.FileMeta,
+				CommitMetas:     session.CommitMetas,
+			},
+			UploadId: session.UploadId,
+			Exists:   false,
+		},
+	}}
+	return api.createFile(ctx, session.ParentId, data)
+}
+
+//PrepareUpload返回的上传计划，调用方自行上传分片后通过CompleteUpload提交
+type UploadPlan struct {
+	UploadSession
+	//云盘已有此文件，无需上传分片，可直接调用CompleteUpload
+	Exists bool
+	//云盘已存在的分片序号，这些分片的commit_meta已记录在CommitMetas中
+	ExistingBlocks []int
+}
+
+//计算分片并创建文件，返回上传节点、file_meta、block_metas等信息，不上传分片
+func (api *api) PrepareUpload(filePath string, parentId string) (*UploadPlan, error) {
+	ctx := context.Background()
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	plan := &UploadPlan{UploadSession: UploadSession{
+		FilePath: absPath,
+		ParentId: parentId,
+		Name:     filepath.Base(absPath),
+		MimeType: detectMimeType(file, absPath),
+		Size:     fileInfo.Size(),
+	}}
+	plan.Sha1, plan.BlockInfos, err = api.getFileBlocks(ctx, file, plan.Size, "")
+	if err != nil {
+		return nil, err
+	}
+	storage, err := api.createStorage(ctx, plan.Name, plan.MimeType, plan.Size, plan.Sha1, plan.BlockInfos)
+	if err != nil {
+		return nil, err
+	}
+	if storage.Get("exists").Bool() {
+		plan.Exists = true
+		plan.UploadId = storage.Get("uploadId").String()
+		return plan, nil
+	}
+	plan.update(storage)
+	if len(plan.NodeUrls) == 0 {
+		return nil, ErrNoUploadNode
+	}
+	for k, v := range plan.BlockMetas {
+		if m := gjson.Parse(v); m.Get("is_existed").Int() == 1 {
+			plan.CommitMetas[k] = map[string]string{"commit_meta": m.Get("commit_meta").String()}
+			plan.ExistingBlocks = append(plan.ExistingBlocks, k)
+		}
+	}
+	return plan, nil
+}
+
+//提交PrepareUpload的上传计划，commitMetas为每个分片上传后返回的commit_meta，
+//已存在的分片可以传空字符串，返回新文件id
+func (api *api) CompleteUpload(plan *UploadPlan, commitMetas []string) (string, error) {
+	ctx := context.Background()
+	if plan.Exists {
+		return api.createExistedFile(ctx, plan.ParentId, plan.Name, plan.MimeType, plan.UploadId)
+	}
+	if len(commitMetas) != len(plan.BlockMetas) {
+		return "", fmt.Errorf("got %d commit metas for %d blocks", len(commitMetas), len(plan.BlockMetas))
+	}
+	session := plan.UploadSession
+	session.CommitMetas = make([]map[string]string, len(commitMetas))
+	for k, v := range commitMetas {
+		if v == "" && plan.CommitMetas[k] != nil {
+			session.CommitMetas[k] = plan.CommitMetas[k]
+			continue
+		}
+		if v == "" {
+			return "", fmt.Errorf("missing commit meta for block %d", k)
+		}
+		session.CommitMetas[k] = map[string]string{"commit_meta": v}
+	}
+	return api.commitSession(ctx, &session)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//上传中途失败后继续上传，只上传缺少的分片
+func TestResumeUploadMissingBlocks(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api(WithChunkSize(16), WithUploadWorkers(1))
+	content := []byte(strings.Repeat("a", 16) + strings.Repeat("b", 16) + strings.Repeat("c", 16) + "ddd")
+	filePath := filepath.Join(t.TempDir(), "a.bin")
+	if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.failBlock = func(blockMeta string) bool { return blockMeta == "bm-2" }
+	var saved []byte
+	save := func(session *UploadSession) (err error) {
+		saved, err = json.Marshal(session)
+		return err
+	}
+	if _, err := api.UploadFileWithSession(filePath, RootFolderId, save); err == nil {
+		t.Fatal("UploadFileWithSession() succeeded while block 2 fails")
+	}
+	var session UploadSession
+	if err := json.Unmarshal(saved, &session); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	d.failBlock = nil
+	d.blockUrls = nil
+	d.mu.Unlock()
+	id, err := api.ResumeUpload(&session, save)
+	if err != nil {
+		t.Fatalf("ResumeUpload() error = %v", err)
+	}
+	d.mu.Lock()
+	blockUrls := d.blockUrls
+	d.mu.Unlock()
+	if len(blockUrls) != 2 || !strings.Contains(blockUrls[0], "block_meta=bm-2") ||
+		!strings.Contains(blockUrls[1], "block_meta=bm-3") {
+		t.Fatalf("ResumeUpload() uploaded %v, want only blocks 2 and 3", blockUrls)
+	}
+	if f := d.file(id); f == nil || !bytes.Equal(f.data, content) {
+		t.Fatalf("resumed file %s does not match content", id)
+	}
+}
+
+func TestUploadNoNode(t *testing.T) {
+	d := newFakeDrive(t)
+	d.nodeUrls = []string{}
+	api := d.api()
+	if _, err := api.UploadBytes([]byte("no node"), "a.txt", RootFolderId); !errors.Is(err, ErrNoUploadNode) {
+		t.Fatalf("UploadBytes() error = %v, want ErrNoUploadNode", err)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CreateShare = BaseUri + "/drive/user/share/create"
+	RevokeShare = BaseUri + "/drive/user/share/%s/cancel"
+)
+
+//分享链接设置
+type ShareOptions struct {
+	//有效期，0表示使用服务端默认有效期
+	ExpiresIn time.Duration
+	//访问密码，为空表示不需要密码
+	Password string
+}
+
+//创建公开分享链接，分享带有提取码时会附加到链接的pwd参数上
+func (api *api) CreateShareLink(id string, opts ShareOptions) (string, error) {
+	form := url.Values{"fileId": []string{id}}
+	if opts.ExpiresIn > 0 {
+		form.Set("expireTime", strconv.FormatInt(int64(opts.ExpiresIn/time.Second), 10))
+	}
+	if opts.Password != "" {
+		form.Set("password", opts.Password)
+	}
+	result, err := api.call(context.Background(), api.endpoint(CreateShare), form)
+	if err != nil {
+		return "", fmt.Errorf("create share link failed, error: %w", err)
+	}
+	var (
+		shareUrl = result.Get("data.url").String()
+		code     = result.Get("data.code").String()
+	)
+	if shareUrl == "" {
+		return "", fmt.Errorf("create share link failed, response: %s", result.Raw)
+	}
+	if code != "" && !strings.Contains(shareUrl, code) {
+		if strings.Contains(shareUrl, "?") {
+			shareUrl += "&pwd=" + url.QueryEscape(code)
+		} else {
+			shareUrl += "?pwd=" + url.QueryEscape(code)
+		}
+	}
+	return shareUrl, nil
+}
+
+//取消文件的分享链接
+func (api *api) RevokeShareLink(id string) error {
+	_, err := api.call(context.Background(), api.endpoint(RevokeShare, id), url.Values{})
+	return err
+}
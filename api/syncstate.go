@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//增量同步时记录的本地文件状态及上传到的目录
+type syncEntry struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Sha1     string    `json:"sha1"`
+	ParentId string    `json:"parent_id"`
+}
+
+//增量同步状态文件，键为相对于同步根目录的路径
+type syncState struct {
+	path  string
+	Files map[string]syncEntry `json:"files"`
+}
+
+//读取状态文件，path为空或文件不存在时返回空状态
+func loadSyncState(path string) (*syncState, error) {
+	state := &syncState{path: path, Files: make(map[string]syncEntry)}
+	if path == "" {
+		return state, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]syncEntry)
+	}
+	return state, nil
+}
+
+//上次同步到同一目录，大小和修改时间都相同，且云盘中同名文件的sha1仍为当时上传的sha1，则认为文件未变化
+//remote为云盘中的同名文件，不存在时为nil
+func (s *syncState) unchanged(key string, info os.FileInfo, parentId string, remote *File) bool {
+	entry, ok := s.Files[key]
+	return ok && remote != nil && entry.ParentId == parentId && entry.Sha1 != "" && entry.Sha1 == remote.Sha1 &&
+		entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime())
+}
+
+func (s *syncState) set(key string, info os.FileInfo, sha1 string, parentId string) {
+	s.Files[key] = syncEntry{Size: info.Size(), ModTime: info.ModTime(), Sha1: sha1, ParentId: parentId}
+}
+
+//先写入临时文件再重命名，避免中断时损坏状态文件
+func (s *syncState) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmpFile := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.path)
+}
+
+func syncKey(root string, p string) string {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadFolderStateFile(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api()
+	localDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(localDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := FolderOptions{StateFile: filepath.Join(t.TempDir(), "state.json")}
+	remoteFile := func(parentId string) *File {
+		files, err := api.GetFolder(parentId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range files {
+			if v.Name == "a.txt" {
+				return v
+			}
+		}
+		return nil
+	}
+	first := d.addFolder(RootFolderId, "first")
+	if _, err := api.UploadFolderWithOptions(localDir, first, opts); err != nil {
+		t.Fatal(err)
+	}
+	if remoteFile(first) == nil {
+		t.Fatal("a.txt not uploaded")
+	}
+	//未变化时跳过
+	plan, err := api.UploadFolderWithOptions(localDir, first, opts)
+	if err != nil || len(plan.Skip) != 1 || len(plan.Create) != 0 {
+		t.Fatalf("second sync plan = %+v, %v, want a.txt skipped", plan, err)
+	}
+	//同一状态文件上传到其他目录
+	second := d.addFolder(RootFolderId, "second")
+	if plan, err := api.UploadFolderWithOptions(localDir, second, opts); err != nil || len(plan.Create) != 1 {
+		t.Fatalf("sync to another folder plan = %+v, %v, want a.txt created", plan, err)
+	}
+	if remoteFile(second) == nil {
+		t.Fatal("a.txt not uploaded to another folder with the same state file")
+	}
+	//云盘中的文件被删除后重新上传
+	if err := api.DeleteFile(remoteFile(second).Id); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := api.UploadFolderWithOptions(localDir, second, opts); err != nil || len(plan.Create) != 1 {
+		t.Fatalf("sync after remote delete plan = %+v, %v, want a.txt created", plan, err)
+	}
+	if remoteFile(second) == nil {
+		t.Fatal("a.txt not uploaded again after remote delete")
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"net/url"
+)
+
+//缩略图尺寸，值为缩略图的最大边长
+type ThumbnailSize int
+
+const (
+	ThumbnailSmall  ThumbnailSize = 120
+	ThumbnailMedium ThumbnailSize = 360
+	ThumbnailLarge  ThumbnailSize = 720
+)
+
+//获取图片文件的缩略图，没有缩略图的文件返回ErrNotAvailable
+func (api *api) GetThumbnail(id string, size ThumbnailSize) ([]byte, error) {
+	ctx := context.Background()
+	metadata, err := api.get(ctx, api.endpoint(GetFiles, id))
+	if err != nil {
+		return nil, err
+	}
+	msg := gjson.ParseBytes(metadata)
+	if msg.Get("result").String() != "ok" {
+		return nil, fmt.Errorf("get file info failed, error: %w", newServerError(msg))
+	}
+	thumbnailUrl := msg.Get("data.storage.thumbnailUrl").String()
+	if thumbnailUrl == "" {
+		thumbnailUrl = msg.Get("data.thumbnailUrl").String()
+	}
+	if thumbnailUrl == "" {
+		return nil, ErrNotAvailable
+	}
+	u, err := url.Parse(thumbnailUrl)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set("w", fmt.Sprint(int(size)))
+	query.Set("h", fmt.Sprint(int(size)))
+	u.RawQuery = query.Encode()
+	return api.get(ctx, u.String())
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"net/url"
+)
+
+const (
+	TrashList    = BaseUri + "/drive/user/recycle/files"
+	TrashRestore = BaseUri + "/drive/user/recycle/files/%s/restore"
+	TrashEmpty   = BaseUri + "/drive/user/recycle/clear"
+)
+
+//获取回收站中的文件，DeleteFile等删除操作会先将文件移入回收站
+func (api *api) ListTrash() ([]*File, error) {
+	result, err := api.get(context.Background(), api.endpoint(TrashList))
+	if err != nil {
+		return nil, err
+	}
+	msg := gjson.ParseBytes(result)
+	if msg.Get("result").String() != "ok" {
+		return nil, fmt.Errorf("list trash failed, error: %w", newServerError(msg))
+	}
+	var files = make([]*File, 0)
+	for _, v := range msg.Get("data.list").Array() {
+		files = append(files, newFile(v))
+	}
+	return files, nil
+}
+
+//从回收站恢复文件到原目录
+func (api *api) RestoreFromTrash(id string) error {
+	_, err := api.call(context.Background(), api.endpoint(TrashRestore, id), url.Values{})
+	//恢复后的文件所在目录未知，清除所有缓存
+	api.cache.clear()
+	return err
+}
+
+//清空回收站，清空后文件无法恢复
+func (api *api) EmptyTrash() error {
+	_, err := api.call(context.Background(), api.endpoint(TrashEmpty), url.Values{})
+	return err
+}
@@ -0,0 +1,1032 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//上传文件
+func (api *api) UploadFile(filePath string, parentId string) (string, error) {
+	return api.UploadFileContext(context.Background(), filePath, parentId)
+}
+
+//上传文件并返回文件大小、sha1及是否秒传等信息
+func (api *api) UploadFileResult(filePath string, parentId string) (*UploadResult, error) {
+	return api.uploadFile(context.Background(), filePath, parentId, UploadOptions{}, nil, nil)
+}
+
+func (api *api) UploadFileContext(ctx context.Context, filePath string, parentId string) (string, error) {
+	return uploadId(api.uploadFile(ctx, filePath, parentId, UploadOptions{}, nil, nil))
+}
+
+//上传文件并回调上传进度，开始时回调0，上传过程中回调已上传大小，完成时回调文件总大小
+func (api *api) UploadFileWithProgress(filePath string, parentId string, onProgress func(uploaded, total int64)) (string, error) {
+	return uploadId(api.uploadFile(context.Background(), filePath, parentId, UploadOptions{}, onProgress, nil))
+}
+
+//使用调用方提供的整个文件的sha1上传，多个分片的文件不再计算整个文件的sha1，开启WithStrictDedup时仍会校验
+func (api *api) UploadFileWithHash(filePath string, parentId string, sha1Hex string) (string, error) {
+	return uploadId(api.uploadFile(context.Background(), filePath, parentId, UploadOptions{Sha1: sha1Hex}, nil, nil))
+}
+
+//按指定设置上传文件
+func (api *api) UploadFileWithOptions(filePath string, parentId string, opts UploadOptions) (string, error) {
+	return uploadId(api.uploadFile(context.Background(), filePath, parentId, opts, nil, nil))
+}
+
+func (api *api) uploadFile(ctx context.Context, filePath string, parentId string, opts UploadOptions,
+	onProgress func(uploaded, total int64), save func(*UploadSession) error) (*UploadResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var persist func(*UploadSession) error
+	if save != nil {
+		persist = func(session *UploadSession) error {
+			session.FilePath = filePath
+			return save(session)
+		}
+	}
+	return api.upload(ctx, file, path.Base(filePath), fileInfo.Size(), parentId, opts, onProgress, persist)
+}
+
+//上传io.Reader中的数据，size为数据大小
+//r同时实现了io.ReaderAt和io.Seeker且可以定位、size不小于0时直接按偏移读取分片
+//否则先将数据缓存到临时文件再上传，此时size小于0表示以实际读取的大小为准
+func (api *api) UploadReader(r io.Reader, name string, size int64, parentId string) (string, error) {
+	return api.UploadReaderWithProgress(r, name, size, parentId, nil)
+}
+
+//上传io.Reader中的数据并回调上传进度，缓存到临时文件的过程不回调
+func (api *api) UploadReaderWithProgress(r io.Reader, name string, size int64, parentId string,
+	onProgress func(uploaded, total int64)) (string, error) {
+	ctx := context.Background()
+	//管道、标准输入等*os.File也实现了io.ReaderAt，但无法定位，需要缓存
+	if readerAt, ok := r.(io.ReaderAt); ok && size >= 0 && seekable(r) {
+		return uploadId(api.upload(ctx, readerAt, name, size, parentId, UploadOptions{}, onProgress, nil))
+	}
+	tmpFile, err := ioutil.TempFile(api.tempDir, "micloud-upload-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	n, err := io.Copy(tmpFile, r)
+	if err != nil {
+		return "", err
+	}
+	if size >= 0 && n != size {
+		return "", fmt.Errorf("read %d bytes from reader, expected %d", n, size)
+	}
+	return uploadId(api.upload(ctx, tmpFile, name, n, parentId, UploadOptions{}, onProgress, nil))
+}
+
+//r是否实现了io.Seeker且可以定位
+func seekable(r io.Reader) bool {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return false
+	}
+	_, err := seeker.Seek(0, io.SeekCurrent)
+	return err == nil
+}
+
+//并发上传多个文件到parentId，concurrency为同时上传的文件数，结果和错误按paths的顺序返回
+func (api *api) UploadFiles(paths []string, parentId string, concurrency int) ([]UploadResult, []error) {
+	return api.UploadFilesContext(context.Background(), paths, parentId, concurrency)
+}
+
+//ctx取消后未开始的文件不再上传，返回ctx的错误
+func (api *api) UploadFilesContext(ctx context.Context, paths []string, parentId string,
+	concurrency int) ([]UploadResult, []error) {
+	var (
+		results = make([]UploadResult, len(paths))
+		errs    = make([]error, len(paths))
+		jobs    = make(chan int)
+		wg      sync.WaitGroup
+	)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				if err := ctx.Err(); err != nil {
+					errs[k] = err
+					continue
+				}
+				result, err := api.uploadFile(ctx, paths[k], parentId, UploadOptions{}, nil, nil)
+				if err != nil {
+					errs[k] = err
+					continue
+				}
+				results[k] = *result
+			}
+		}()
+	}
+	for k := range paths {
+		jobs <- k
+	}
+	close(jobs)
+	wg.Wait()
+	return results, errs
+}
+
+//上传内存中的数据，如生成的配置文件
+func (api *api) UploadBytes(data []byte, name string, parentId string) (string, error) {
+	return uploadId(api.upload(context.Background(), bytes.NewReader(data), name, int64(len(data)), parentId,
+		UploadOptions{}, nil, nil))
+}
+
+//上传整个目录下的内容到parentId，保持目录结构，远程已存在同名且sha1相同的文件跳过，sha1不同的文件覆盖
+//单个文件失败不会中断上传，结束后以MultiError返回所有失败的文件
+func (api *api) UploadFolder(localDir string, parentId string) error {
+	_, err := api.UploadFolderWithOptions(localDir, parentId, FolderOptions{})
+	return err
+}
+
+//按指定设置上传整个目录，返回每个文件的处理结果，DryRun时只返回计划不上传
+func (api *api) UploadFolderWithOptions(localDir string, parentId string, opts FolderOptions) (*SyncPlan, error) {
+	var (
+		ctx       = context.Background()
+		root      = filepath.Clean(localDir)
+		folderIds = map[string]string{root: parentId}
+		remotes   = make(map[string][]*File)
+		failed    = make(map[string]error)
+		plan      = &SyncPlan{}
+	)
+	state, err := loadSyncState(opts.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	//获取远程目录下的文件，目录不存在(id为空)时返回空列表
+	listRemote := func(id string) ([]*File, error) {
+		if id == "" {
+			return nil, nil
+		}
+		files, ok := remotes[id]
+		if !ok {
+			var err error
+			if files, err = api.GetFolderContext(ctx, id); err != nil {
+				return nil, err
+			}
+			remotes[id] = files
+		}
+		return files, nil
+	}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if p == root {
+			return err
+		}
+		if err != nil {
+			failed[p] = err
+			return nil
+		}
+		//父目录创建失败时跳过
+		parentId, ok := folderIds[filepath.Dir(p)]
+		if !ok {
+			return nil
+		}
+		if d.IsDir() {
+			if opts.DryRun {
+				files, err := listRemote(parentId)
+				if err != nil {
+					failed[p] = err
+					return filepath.SkipDir
+				}
+				folderIds[p] = ""
+				for _, v := range files {
+					if v.Name == d.Name() && v.IsFolder() {
+						folderIds[p] = v.Id
+					}
+				}
+				return nil
+			}
+			//已存在的目录使用其id，无法获取id时按失败处理，避免文件上传到空的目录id
+			id, err := api.CreateFolder(d.Name(), parentId)
+			if errors.Is(err, ErrAlreadyExists) && id != "" {
+				err = nil
+			}
+			if err != nil {
+				failed[p] = err
+				return filepath.SkipDir
+			}
+			folderIds[p] = id
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			failed[p] = err
+			return nil
+		}
+		files, err := listRemote(parentId)
+		if err != nil {
+			failed[p] = err
+			return nil
+		}
+		var remote *File
+		for _, v := range files {
+			if v.Name == d.Name() && v.Type == "file" {
+				remote = v
+			}
+		}
+		//状态文件只能说明本地未变化，还需确认云盘中的文件仍在原目录且未被修改
+		key := syncKey(root, p)
+		if state.unchanged(key, info, parentId, remote) {
+			plan.Skip = append(plan.Skip, p)
+			return nil
+		}
+		var (
+			localSha1 string
+			existed   = remote != nil
+		)
+		if existed {
+			localSha1 = calFileHash(p, "sha1")
+			if remote.Sha1 == localSha1 {
+				state.set(key, info, localSha1, parentId)
+				plan.Skip = append(plan.Skip, p)
+				return nil
+			}
+		}
+		if existed {
+			plan.Overwrite = append(plan.Overwrite, p)
+		} else {
+			plan.Create = append(plan.Create, p)
+		}
+		if opts.DryRun {
+			return nil
+		}
+		uploadOpts := UploadOptions{Sha1: localSha1}
+		if existed {
+			uploadOpts.OnConflict = OnConflictOverwrite
+		}
+		result, err := api.uploadFile(ctx, p, parentId, uploadOpts, nil, nil)
+		if err != nil {
+			failed[p] = err
+			return nil
+		}
+		state.set(key, info, result.Sha1, parentId)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !opts.DryRun {
+		if err := state.save(); err != nil {
+			return plan, err
+		}
+	}
+	if len(failed) > 0 {
+		return plan, &MultiError{Failed: failed}
+	}
+	return plan, nil
+}
+
+//严格秒传校验时大文件比较的字节数
+const dedupSampleSize = 64 * 1024
+
+//分片缓冲区，避免每个分片都重新分配内存
+var blockPool sync.Pool
+
+//从blockPool取出至少size大小的缓冲区，用完后需放回
+func getBlockBuffer(size int64) *[]byte {
+	if bufPtr, ok := blockPool.Get().(*[]byte); ok && int64(cap(*bufPtr)) >= size {
+		return bufPtr
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+//上传中的分片缓冲区，所有引用都释放后才放回blockPool
+//请求返回后net/http可能仍在读取请求内容，每个请求内容各持有一个引用，关闭时释放
+type blockBuffer struct {
+	bufPtr *[]byte
+	refs   int32
+}
+
+func newBlockBuffer(size int64) *blockBuffer {
+	return &blockBuffer{bufPtr: getBlockBuffer(size), refs: 1}
+}
+
+func (b *blockBuffer) retain() {
+	atomic.AddInt32(&b.refs, 1)
+}
+
+func (b *blockBuffer) release() {
+	if atomic.AddInt32(&b.refs, -1) == 0 {
+		blockPool.Put(b.bufPtr)
+	}
+}
+
+//分片请求内容，net/http发送完或放弃请求后调用Close释放缓冲区，多次调用只释放一次
+type blockBody struct {
+	io.Reader
+	buf  *blockBuffer
+	once sync.Once
+}
+
+func (b *blockBody) Close() error {
+	b.once.Do(b.buf.release)
+	return nil
+}
+
+//上传r中大小为fileSize的数据
+func (api *api) upload(ctx context.Context, r io.ReaderAt, fileName string, fileSize int64, parentId string,
+	opts UploadOptions, onProgress func(uploaded, total int64), save func(*UploadSession) error) (*UploadResult, error) {
+	if fileSize < 0 {
+		return nil, fmt.Errorf("invalid file size %d", fileSize)
+	}
+	if onProgress == nil {
+		onProgress = func(uploaded, total int64) {}
+	}
+	if opts.OnConflict != OnConflictNone {
+		existed, err := api.resolveConflict(ctx, parentId, fileName, opts.OnConflict)
+		if err != nil {
+			return nil, err
+		}
+		if opts.OnConflict == OnConflictSkip && existed != "" {
+			return &UploadResult{Id: existed, Name: fileName}, nil
+		}
+		if opts.OnConflict == OnConflictRename {
+			fileName = existed
+		}
+	}
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		mimeType = detectMimeType(r, fileName)
+	}
+	if api.maxFileSize > 0 && fileSize >= api.maxFileSize {
+		return nil, fmt.Errorf("can not upload file big than %d bytes", api.maxFileSize)
+	}
+	if api.quotaCheck {
+		if err := api.checkQuota(ctx, fileSize); err != nil {
+			return nil, err
+		}
+	}
+	onProgress(0, fileSize)
+	var (
+		fileSha1   string
+		blockInfos []BlockInfo
+		storage    gjson.Result
+		err        error
+	)
+	//调用方提供了sha1时不再计算，严格模式下仍然计算并比较
+	if opts.Sha1 != "" {
+		fileSha1 = strings.ToLower(opts.Sha1)
+	}
+	//多个分片的文件先只用sha1确认云盘是否已有此文件，已有则无需再计算分片
+	if fileSize > api.chunkSize {
+		if fileSha1 == "" || api.strictDedup {
+			hash := sha1.New()
+			if _, err := io.Copy(hash, &ctxReader{ctx: ctx, reader: io.NewSectionReader(r, 0, fileSize)}); err != nil {
+				return nil, err
+			}
+			sum := fmt.Sprintf("%x", hash.Sum(nil))
+			if fileSha1 != "" && fileSha1 != sum {
+				return nil, fmt.Errorf("sha1 %s does not match file content %s: %w", fileSha1, sum, ErrSha1Mismatch)
+			}
+			fileSha1 = sum
+		}
+		if storage, err = api.createStorage(ctx, fileName, mimeType, fileSize, fileSha1, nil); err != nil {
+			return nil, err
+		}
+	}
+	if !storage.Get("exists").Bool() {
+		//小于4MB的文件只有一个分片
+		givenSha1 := fileSha1
+		fileSha1, blockInfos, err = api.getFileBlocks(ctx, r, fileSize, fileSha1)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("get file blocks failed, error: %w", err)
+		}
+		//只有一个分片时sha1由分片计算得到，可直接比较
+		if givenSha1 != "" && givenSha1 != fileSha1 {
+			return nil, fmt.Errorf("sha1 %s does not match file content %s: %w", givenSha1, fileSha1, ErrSha1Mismatch)
+		}
+		if storage, err = api.createStorage(ctx, fileName, mimeType, fileSize, fileSha1, blockInfos); err != nil {
+			return nil, err
+		}
+	}
+	var id string
+	//云盘已有此文件
+	if storage.Get("exists").Bool() {
+		id, err = api.createExistedFile(ctx, parentId, fileName, mimeType, storage.Get("uploadId").String())
+		if err == nil && api.strictDedup {
+			if err = api.verifyDedup(ctx, id, r, fileSize); err != nil {
+				api.logger.Errorf("verify deduplicated file %s failed, error: %s", fileName, err)
+				_ = api.DeleteFileContext(ctx, id)
+			}
+		}
+	} else {
+		//云盘不存在该文件
+		session := &UploadSession{
+			ParentId:   parentId,
+			Name:       fileName,
+			MimeType:   mimeType,
+			Size:       fileSize,
+			Sha1:       fileSha1,
+			BlockInfos: blockInfos,
+		}
+		session.update(storage)
+		id, err = api.uploadSession(ctx, r, session, onProgress, save)
+	}
+	if err != nil {
+		return nil, err
+	}
+	onProgress(fileSize, fileSize)
+	return &UploadResult{
+		Id:           id,
+		Name:         fileName,
+		Size:         fileSize,
+		Sha1:         fileSha1,
+		Deduplicated: storage.Get("exists").Bool(),
+	}, nil
+}
+
+//只返回上传结果中的文件id
+func uploadId(result *UploadResult, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return result.Id, nil
+}
+
+//比较秒传文件与本地数据，小文件比较全部内容，大文件只比较开头和结尾各dedupSampleSize字节
+func (api *api) verifyDedup(ctx context.Context, id string, r io.ReaderAt, fileSize int64) error {
+	storage, err := api.getFileStorage(ctx, id)
+	if err != nil {
+		return err
+	}
+	var ranges = [][2]int64{{0, fileSize}}
+	if fileSize > api.chunkSize && fileSize > 2*dedupSampleSize {
+		ranges = [][2]int64{{0, dedupSampleSize}, {fileSize - dedupSampleSize, fileSize}}
+	}
+	for _, v := range ranges {
+		//范围限制在[0, fileSize)内
+		start, end := v[0], v[1]
+		if start < 0 {
+			start = 0
+		}
+		if end > fileSize {
+			end = fileSize
+		}
+		if start >= end {
+			continue
+		}
+		local := make([]byte, end-start)
+		n, err := r.ReadAt(local, start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		//只比较实际读取的部分
+		local = local[:n]
+		end = start + int64(n)
+		if start >= end {
+			continue
+		}
+		resp, err := api.openFileRange(ctx, storage, start, end-1)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(resp); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		if start > 0 && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return fmt.Errorf("range request not supported, status: %d", resp.StatusCode)
+		}
+		remote, err := ioutil.ReadAll(io.LimitReader(resp.Body, end-start))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(local, remote) {
+			return fmt.Errorf("deduplicated file differs in range %d-%d: %w", start, end-1, ErrSha1Mismatch)
+		}
+	}
+	return nil
+}
+
+//处理目录下的同名文件，Skip时返回已有文件的id，Rename时返回可用的文件名
+func (api *api) resolveConflict(ctx context.Context, parentId string, fileName string, policy ConflictPolicy) (string, error) {
+	files, err := api.GetFolderContext(ctx, parentId)
+	if err != nil {
+		return "", err
+	}
+	var names = make(map[string]*File, len(files))
+	for _, v := range files {
+		names[v.Name] = v
+	}
+	existed, ok := names[fileName]
+	switch policy {
+	case OnConflictSkip:
+		if ok && existed.Type == "file" {
+			return existed.Id, nil
+		}
+		return "", nil
+	case OnConflictOverwrite:
+		if ok && existed.Type == "file" {
+			return "", api.DeleteFileContext(ctx, existed.Id)
+		}
+		return "", nil
+	case OnConflictRename:
+		var (
+			ext  = path.Ext(fileName)
+			base = strings.TrimSuffix(fileName, ext)
+			name = fileName
+		)
+		for i := 1; ; i++ {
+			if _, ok := names[name]; !ok {
+				return name, nil
+			}
+			name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+	}
+	return "", nil
+}
+
+//根据扩展名识别文件类型，无法识别时根据前512字节的内容识别
+func detectMimeType(r io.ReaderAt, fileName string) string {
+	if mimeType := mime.TypeByExtension(path.Ext(fileName)); mimeType != "" {
+		return mimeType
+	}
+	buf := make([]byte, 512)
+	n, _ := r.ReadAt(buf, 0)
+	return http.DetectContentType(buf[:n])
+}
+
+//提交文件大小、sha1及分片信息，返回接口中的data.storage
+func (api *api) createStorage(ctx context.Context, fileName string, mimeType string, fileSize int64, fileSha1 string,
+	blockInfos []BlockInfo) (storage gjson.Result, err error) {
+	err = api.withAuth(func() error {
+		storage, err = api.createStorageOnce(ctx, fileName, mimeType, fileSize, fileSha1, blockInfos)
+		return err
+	})
+	if err != nil {
+		return storage, err
+	}
+	return storage, checkBlockSize(storage, blockInfos)
+}
+
+//服务端在kss.block_size中返回要求的分片大小时，校验本次分片是否符合
+//除最后一个分片外每个分片都应等于该大小，最后一个分片不能超过该大小
+func checkBlockSize(storage gjson.Result, blockInfos []BlockInfo) error {
+	blockSize := storage.Get("kss.block_size").Int()
+	if blockSize <= 0 {
+		return nil
+	}
+	for k, v := range blockInfos {
+		if v.Size > blockSize || (k < len(blockInfos)-1 && v.Size != blockSize) {
+			return fmt.Errorf("server expects %d bytes per block, block %d has %d bytes, adjust WithChunkSize: %w",
+				blockSize, k, v.Size, ErrBlockSizeMismatch)
+		}
+	}
+	return nil
+}
+
+func (api *api) createStorageOnce(ctx context.Context, fileName string, mimeType string, fileSize int64, fileSha1 string,
+	blockInfos []BlockInfo) (gjson.Result, error) {
+	var uploadJson = UploadJson{
+		Content: UploadContent{
+			Name:     fileName,
+			MimeType: mimeType,
+			Storage: UploadStorage{
+				Size: fileSize,
+				Sha1: fileSha1,
+				Kss: UploadKss{
+					BlockInfos: blockInfos,
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(uploadJson)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	//创建分片
+	api.logger.Debugf("create file %s, size: %d, sha1: %s, blocks: %d", fileName, fileSize, fileSha1, len(blockInfos))
+	resp, err := api.postForm(ctx, api.endpoint(CreateFile), url.Values{
+		"data":         []string{string(data)},
+		"serviceToken": []string{api.user.Token()},
+	})
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	defer resp.Body.Close()
+	all, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	api.logger.Debugf("create file %s response, status: %d, result: %s, code: %d", fileName, resp.StatusCode,
+		gjson.GetBytes(all, "result").String(), gjson.GetBytes(all, "code").Int())
+	if result := gjson.Get(string(all), "result").String(); result != "ok" {
+		return gjson.Result{}, fmt.Errorf("create file failed, error: %w", newServerError(gjson.ParseBytes(all)))
+	}
+	return gjson.GetBytes(all, "data.storage"), nil
+}
+
+//并发上传未提交的分片，commitMetas按分片顺序写入session，任一分片失败则取消其余分片
+//每个分片完成后调用save保存session，保存失败只记录日志不中断上传
+func (api *api) uploadBlocks(ctx context.Context, nodeUrls []string, r io.ReaderAt, session *UploadSession,
+	onProgress func(uploaded, total int64), save func(*UploadSession) error) error {
+	var (
+		jobs     = make(chan int)
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		uploaded int64
+		total    = session.Size
+		//上传中的分片已发送的字节数
+		inflight = make(map[int]int64)
+	)
+	report := func() {
+		var sent = uploaded
+		for _, v := range inflight {
+			sent += v
+		}
+		onProgress(sent, total)
+	}
+	if len(session.BlockInfos) != len(session.BlockMetas) {
+		return fmt.Errorf("server returned %d block metas for %d blocks", len(session.BlockMetas), len(session.BlockInfos))
+	}
+	//按分片大小计算每个分片在文件中的偏移
+	var offsets = make([]int64, len(session.BlockInfos))
+	for k := 1; k < len(offsets); k++ {
+		offsets[k] = offsets[k-1] + session.BlockInfos[k-1].Size
+	}
+	//之前已提交的分片计入已上传大小
+	for k, v := range session.CommitMetas {
+		if v != nil {
+			uploaded += session.BlockInfos[k].Size
+		}
+	}
+	if uploaded > 0 {
+		onProgress(uploaded, total)
+	}
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workers := api.uploadWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				onSent := func(k int) func(int64) {
+					return func(sent int64) {
+						mu.Lock()
+						inflight[k] = sent
+						report()
+						mu.Unlock()
+					}
+				}(k)
+				commitMeta, err := api.uploadBlock(uploadCtx, k, nodeUrls, session.FileMeta, r, offsets[k],
+					session.BlockInfos[k].Size, gjson.Parse(session.BlockMetas[k]), onSent)
+				mu.Lock()
+				delete(inflight, k)
+				if err != nil {
+					if firstErr == nil {
+						firstErr = &UploadBlockError{Index: k, Total: len(session.BlockMetas), Err: err}
+						cancel()
+					}
+				} else {
+					session.CommitMetas[k] = commitMeta
+					uploaded += session.BlockInfos[k].Size
+					report()
+					if api.onBlock != nil {
+						api.onBlock(BlockEvent{
+							Name:    session.Name,
+							Index:   k,
+							Total:   len(session.BlockMetas),
+							Size:    session.BlockInfos[k].Size,
+							Existed: gjson.Get(session.BlockMetas[k], "is_existed").Int() == 1,
+						})
+					}
+					if save != nil {
+						if err := save(session); err != nil {
+							api.logger.Errorf("save upload session failed, error: %s", err)
+						}
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+Loop:
+	for k := range session.BlockMetas {
+		if session.CommitMetas[k] != nil {
+			continue
+		}
+		select {
+		case jobs <- k:
+		case <-uploadCtx.Done():
+			break Loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+//并发计算每个分片的sha1、md5，同时顺序读取整个文件计算sha1
+//每个worker只持有一个分片大小的缓冲区，blockInfos按分片顺序返回
+//创建文件接口要求每个分片同时提供sha1和md5，整个文件只需要sha1
+//fileSha1不为空时表示已计算过整个文件的sha1，只有一个分片时整个文件的sha1即分片的sha1，这两种情况不再重复读取文件
+//ctx取消时停止计算并返回ctx的错误
+func (api *api) getFileBlocks(ctx context.Context, r io.ReaderAt, fileSize int64, fileSha1 string) (string, []BlockInfo, error) {
+	chunkSize := api.chunkSize
+	num := int(math.Ceil(float64(fileSize) / float64(chunkSize)))
+	//空文件也需要一个大小为0的分片
+	if num == 0 {
+		num = 1
+	}
+	var (
+		blockInfos = make([]BlockInfo, num)
+		jobs       = make(chan int)
+		errs       = make(chan error, 1)
+		wg         sync.WaitGroup
+		done       = make(chan struct{})
+	)
+	setErr := func(err error) {
+		select {
+		case errs <- err:
+			close(done)
+		default:
+		}
+	}
+	//整个文件的sha1只能顺序计算
+	if fileSha1 == "" && num > 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hash := sha1.New()
+			if _, err := io.Copy(hash, &ctxReader{ctx: ctx, reader: io.NewSectionReader(r, 0, fileSize)}); err != nil {
+				setErr(fmt.Errorf("read file failed, error: %w", err))
+				return
+			}
+			fileSha1 = fmt.Sprintf("%x", hash.Sum(nil))
+		}()
+	}
+	workers := runtime.NumCPU()
+	if workers > num {
+		workers = num
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bufPtr := getBlockBuffer(chunkSize)
+			defer blockPool.Put(bufPtr)
+			buf := *bufPtr
+			for k := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				offset := int64(k) * chunkSize
+				size := fileSize - offset
+				if size > chunkSize {
+					size = chunkSize
+				}
+				n, err := r.ReadAt(buf[:size], offset)
+				if int64(n) != size {
+					setErr(fmt.Errorf("read block %d failed, error: %w", k, err))
+					continue
+				}
+				blockSha1, blockMd5 := sha1.Sum(buf[:size]), md5.Sum(buf[:size])
+				blockInfos[k] = BlockInfo{
+					Blob: struct{}{},
+					Sha1: fmt.Sprintf("%x", blockSha1),
+					Md5:  fmt.Sprintf("%x", blockMd5),
+					Size: size,
+				}
+			}
+		}()
+	}
+Loop:
+	for k := 0; k < num; k++ {
+		select {
+		case jobs <- k:
+		case <-done:
+			break Loop
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+	select {
+	case err := <-errs:
+		return "", nil, err
+	default:
+	}
+	if num == 1 {
+		fileSha1 = blockInfos[0].Sha1
+	}
+	return fileSha1, blockInfos, nil
+}
+
+//上传文件分片
+//offset和size为分片在文件中的位置和大小，onSent回调本次请求已发送的字节数，重试时从0开始
+//连接上传节点失败时依次换用nodeUrls中的下一个节点
+func (api *api) uploadBlock(ctx context.Context, num int, nodeUrls []string, fileMeta string, r io.ReaderAt, offset, size int64,
+	m gjson.Result, onSent func(sent int64)) (map[string]string, error) {
+	//block已存在则不上传
+	if m.Get("is_existed").Int() == 1 {
+		api.logger.Debugf("block %d already existed, skip upload", num)
+		return map[string]string{"commit_meta": m.Get("commit_meta").String()}, nil
+	} else {
+		//chunk_pos是本次上传的数据在分片内的偏移，分片的位置由block_meta确定
+		//每个分片都在一次请求中完整上传，所以chunk_pos始终为0
+		const chunkPos = 0
+		buf := newBlockBuffer(size)
+		defer buf.release()
+		fileBlock := (*buf.bufPtr)[:size]
+		n, err := r.ReadAt(fileBlock, offset)
+		if n != len(fileBlock) {
+			return nil, err
+		}
+		var commitMeta string
+		api.logger.Debugf("upload block %d start, %d bytes", num, len(fileBlock))
+		for i, apiNode := range nodeUrls {
+			uploadUrl := fmt.Sprintf("%s/upload_block_chunk?chunk_pos=%d&file_meta=%s&block_meta=%s",
+				apiNode, chunkPos, fileMeta, m.Get("block_meta").String())
+			err = api.retry(ctx, func() error {
+				return api.withAuth(func() error {
+					commitMeta, err = api.postBlock(ctx, uploadUrl, buf, fileBlock, onSent)
+					return err
+				})
+			})
+			if err == nil || ctx.Err() != nil || !isConnError(err) || i == len(nodeUrls)-1 {
+				break
+			}
+			api.logger.Errorf("upload block %d to node %s failed, trying next node, error: %s", num, apiNode, err)
+		}
+		if err != nil {
+			api.logger.Errorf("upload block %d failed, error: %s", num, err)
+			if api.chunkSize != ChunkSize {
+				api.logger.Errorf("chunk size %d is not the default %d, server may reject it", api.chunkSize, ChunkSize)
+			}
+			return nil, err
+		}
+		api.logger.Debugf("upload block %d completed", num)
+		return map[string]string{"commit_meta": commitMeta}, nil
+	}
+}
+
+//fileBlock为buf中的数据，请求内容关闭前buf不会被复用
+func (api *api) postBlock(ctx context.Context, uploadUrl string, buf *blockBuffer, fileBlock []byte,
+	onSent func(sent int64)) (string, error) {
+	var body io.Reader = bytes.NewReader(fileBlock)
+	if api.byteLimiter != nil {
+		body = &throttledReader{ctx: ctx, reader: body, limiter: api.byteLimiter}
+	}
+	if onSent != nil {
+		body = NewProgressReader(body, int64(len(fileBlock)), func(read, total int64) {
+			onSent(read)
+		})
+	}
+	buf.retain()
+	blockBody := &blockBody{Reader: body, buf: buf}
+	request, err := http.NewRequestWithContext(ctx, "POST", uploadUrl, blockBody)
+	if err != nil {
+		_ = blockBody.Close()
+		return "", err
+	}
+	//包装后的body无法自动获取长度，需要手动设置，否则会使用chunked编码
+	request.ContentLength = int64(len(fileBlock))
+	request.Header.Set("Content-Type", "application/octet-stream")
+	response, err := api.do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusUnauthorized {
+		return "", ErrAuthExpired
+	}
+	if response.StatusCode >= http.StatusInternalServerError || response.StatusCode == http.StatusTooManyRequests {
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, statusErrorBodySize))
+		return "", fmt.Errorf("upload block failed, error: %w", newStatusError(response, body))
+	}
+	readAll, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	stat := gjson.Get(string(readAll), "stat").String()
+	if stat != "BLOCK_COMPLETED" {
+		return "", errors.New("block not completed")
+	}
+	//响应中有大小或偏移时校验是否与发送的分片一致
+	if size := gjson.GetBytes(readAll, "size"); size.Exists() && size.Int() != int64(len(fileBlock)) {
+		return "", fmt.Errorf("block size mismatch, sent %d bytes, server received %d", len(fileBlock), size.Int())
+	}
+	if offset := gjson.GetBytes(readAll, "offset"); offset.Exists() && offset.Int() != 0 {
+		return "", fmt.Errorf("block offset mismatch, sent at 0, server received at %d", offset.Int())
+	}
+	return gjson.Get(string(readAll), "commit_meta").String(), nil
+}
+
+//云盘已有相同文件时直接创建文件
+func (api *api) createExistedFile(ctx context.Context, parentId string, fileName string, mimeType string,
+	uploadId string) (string, error) {
+	data := UploadJson{Content: UploadContent{
+		Name:     fileName,
+		MimeType: mimeType,
+		Storage: UploadExistedStorage{
+			UploadId: uploadId,
+			Exists:   true,
+		},
+	}}
+	return api.createFile(ctx, parentId, data)
+}
+
+//最终创建文件，重复提交可能创建多个文件，只在服务端返回Retry-After时重试
+func (api *api) createFile(ctx context.Context, parentId string, data interface{}) (id string, err error) {
+	err = api.retryThrottled(ctx, func() error {
+		return api.withAuth(func() error {
+			id, err = api.createFileOnce(ctx, parentId, data)
+			return err
+		})
+	})
+	return id, err
+}
+
+func (api *api) createFileOnce(ctx context.Context, parentId string, data interface{}) (string, error) {
+	dataJson, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Add("data", string(dataJson))
+	form.Add("serviceToken", api.user.Token())
+	form.Add("parentId", parentId)
+	request, err := http.NewRequestWithContext(ctx, "POST", api.endpoint(UploadFile), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := api.do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	api.cache.invalidate(parentId)
+	readAll, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
+		return "", newStatusError(response, readAll)
+	}
+	api.logger.Debugf("commit file response, status: %d, result: %s, code: %d", response.StatusCode,
+		gjson.GetBytes(readAll, "result").String(), gjson.GetBytes(readAll, "code").Int())
+	if result := gjson.Get(string(readAll), "result").String(); result != "ok" {
+		return "", newServerError(gjson.ParseBytes(readAll))
+	} else {
+		id := gjson.Get(string(readAll), "data.id").String()
+		if id == "" {
+			return "", fmt.Errorf("commit file succeeded but no id returned, response: %s", readAll)
+		}
+		return id, nil
+	}
+}
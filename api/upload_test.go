@@ -0,0 +1,267 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUploadReaderPipe(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api(WithTempDir(t.TempDir()))
+	content := bytes.Repeat([]byte("pipe"), 1000)
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = pw.Write(content)
+		_ = pw.Close()
+	}()
+	defer pr.Close()
+	id, err := api.UploadReader(pr, "pipe.bin", -1, RootFolderId)
+	if err != nil {
+		t.Fatalf("UploadReader(pipe) error = %v", err)
+	}
+	if f := d.file(id); f == nil || !bytes.Equal(f.data, content) {
+		t.Fatalf("uploaded file %s does not match pipe content", id)
+	}
+}
+
+func TestUploadReaderUnknownSize(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api(WithTempDir(t.TempDir()))
+	content := []byte("unknown size")
+	id, err := api.UploadReader(bytes.NewReader(content), "a.txt", -1, RootFolderId)
+	if err != nil {
+		t.Fatalf("UploadReader(size=-1) error = %v", err)
+	}
+	if f := d.file(id); f == nil || !bytes.Equal(f.data, content) {
+		t.Fatalf("uploaded file %s does not match content", id)
+	}
+	if _, err := api.upload(context.Background(), bytes.NewReader(content), "a.txt", -1, RootFolderId,
+		UploadOptions{}, nil, nil); err == nil {
+		t.Fatal("upload() with negative size succeeded")
+	}
+}
+
+func TestGetFileBlocksPartialLastBlock(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api(WithChunkSize(10))
+	content := []byte("0123456789abcdefghijKLMNO")
+	fileSha1, blocks, err := api.getFileBlocks(context.Background(), bytes.NewReader(content), int64(len(content)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%x", sha1.Sum(content)); fileSha1 != want {
+		t.Fatalf("file sha1 = %s, want %s", fileSha1, want)
+	}
+	wantBlocks := [][]byte{content[:10], content[10:20], content[20:]}
+	if len(blocks) != len(wantBlocks) {
+		t.Fatalf("got %d blocks, want %d", len(blocks), len(wantBlocks))
+	}
+	for k, v := range wantBlocks {
+		if blocks[k].Size != int64(len(v)) || blocks[k].Sha1 != fmt.Sprintf("%x", sha1.Sum(v)) {
+			t.Fatalf("block %d = %+v, want size %d sha1 %x", k, blocks[k], len(v), sha1.Sum(v))
+		}
+	}
+}
+
+//按偏移生成内容的ReaderAt，不占用内存，记录每次读取的偏移
+type sparseReader struct {
+	size    int64
+	mu      sync.Mutex
+	offsets []int64
+}
+
+func (r *sparseReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	r.offsets = append(r.offsets, off)
+	r.mu.Unlock()
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if int64(n) > r.size-off {
+		n = int(r.size - off)
+	}
+	for i := 0; i < n; i++ {
+		p[i] = byte((off + int64(i)) % 251)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+//前2GB的分片都已存在，只上传2GB之后的分片，检查读取偏移和上传内容
+func TestUploadBlocksPast2GB(t *testing.T) {
+	d := newFakeDrive(t)
+	const chunkSize = 64 * 1024
+	api := d.api(WithChunkSize(chunkSize))
+	var (
+		base     = int64(2) << 30
+		existed  = int(base / chunkSize)
+		size     = base + 2*chunkSize + 100
+		num      = existed + 3
+		r        = &sparseReader{size: size}
+		session  = &UploadSession{Name: "big.bin", Size: size, FileMeta: "fm"}
+		expected = make(map[string]int64)
+	)
+	for k := 0; k < num; k++ {
+		blockSize := int64(chunkSize)
+		if k == num-1 {
+			blockSize = 100
+		}
+		session.BlockInfos = append(session.BlockInfos, BlockInfo{Size: blockSize})
+		isExisted := 1
+		if k >= existed {
+			isExisted = 0
+			offset := int64(k) * chunkSize
+			block := make([]byte, blockSize)
+			_, _ = (&sparseReader{size: size}).ReadAt(block, offset)
+			expected[fmt.Sprintf("%x", sha1.Sum(block))] = offset
+		}
+		session.BlockMetas = append(session.BlockMetas,
+			fmt.Sprintf(`{"block_meta":"bm-%d","commit_meta":"cm-%d","is_existed":%d}`, k, k, isExisted))
+	}
+	session.CommitMetas = make([]map[string]string, num)
+	nodeUrls := []string{d.srv.URL + "/node"}
+	if err := api.uploadBlocks(context.Background(), nodeUrls, r, session, func(uploaded, total int64) {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(r.offsets, func(i, j int) bool { return r.offsets[i] < r.offsets[j] })
+	want := []int64{base, base + chunkSize, base + 2*chunkSize}
+	if fmt.Sprint(r.offsets) != fmt.Sprint(want) {
+		t.Fatalf("read offsets %v, want %v", r.offsets, want)
+	}
+	for sum, offset := range expected {
+		if !d.hasBlock(sum) {
+			t.Fatalf("block at offset %d not uploaded", offset)
+		}
+	}
+	for k := 0; k < num; k++ {
+		if session.CommitMetas[k] == nil {
+			t.Fatalf("block %d not committed", k)
+		}
+	}
+}
+
+func TestUploadEmptyFile(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api()
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "empty.txt")
+	if err := ioutil.WriteFile(filePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	id, err := api.UploadFile(filePath, RootFolderId)
+	if err != nil {
+		t.Fatalf("UploadFile(empty) error = %v", err)
+	}
+	files, err := api.GetFolder(RootFolderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Id != id || files[0].Name != "empty.txt" || files[0].Size != 0 {
+		t.Fatalf("GetFolder() = %+v, want one empty file %s", files, id)
+	}
+	destPath := filepath.Join(dir, "download.txt")
+	if err := api.DownloadFileContext(context.Background(), id, destPath); err != nil {
+		t.Fatalf("DownloadFileContext(empty) error = %v", err)
+	}
+	if info, err := os.Stat(destPath); err != nil || info.Size() != 0 {
+		t.Fatalf("downloaded file = %v, %v, want 0 bytes", info, err)
+	}
+}
+
+//每个分片一次完整上传，chunk_pos始终为0，分片由block_meta区分
+func TestUploadBlockUrls(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api(WithChunkSize(8), WithUploadWorkers(1))
+	if _, err := api.UploadBytes([]byte("aaaaaaaabbbbbbbbccc"), "a.bin", RootFolderId); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.blockUrls) != 3 {
+		t.Fatalf("uploaded %d blocks, want 3", len(d.blockUrls))
+	}
+	first, err := url.Parse(d.blockUrls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileMeta := first.Query().Get("file_meta")
+	if !strings.HasPrefix(fileMeta, "fm-up-") {
+		t.Fatalf("file_meta = %q, want the one returned by create", fileMeta)
+	}
+	for k, v := range d.blockUrls {
+		want := fmt.Sprintf("/node/upload_block_chunk?chunk_pos=0&file_meta=%s&block_meta=bm-%d", fileMeta, k)
+		if v != want {
+			t.Fatalf("block %d url = %s, want %s", k, v, want)
+		}
+	}
+}
+
+type failingReader struct{ err error }
+
+func (r failingReader) ReadAt([]byte, int64) (int, error) { return 0, r.err }
+
+func TestUploadWrapsReadError(t *testing.T) {
+	d := newFakeDrive(t)
+	api := d.api()
+	errRead := errors.New("disk failure")
+	_, err := api.upload(context.Background(), failingReader{err: errRead}, "a.bin", 100, RootFolderId,
+		UploadOptions{MimeType: "application/octet-stream"}, nil, nil)
+	if !errors.Is(err, errRead) {
+		t.Fatalf("upload() error = %v, want wrapped read error", err)
+	}
+}
+
+//uploadBlock返回后请求内容仍未关闭时缓冲区不能放回blockPool
+func TestBlockBufferReleasedAfterBodyClose(t *testing.T) {
+	buf := newBlockBuffer(16)
+	buf.retain()
+	body := &blockBody{Reader: bytes.NewReader((*buf.bufPtr)[:16]), buf: buf}
+	buf.release()
+	if refs := atomic.LoadInt32(&buf.refs); refs != 1 {
+		t.Fatalf("refs = %d before body closed, want 1", refs)
+	}
+	_ = body.Close()
+	_ = body.Close()
+	if refs := atomic.LoadInt32(&buf.refs); refs != 0 {
+		t.Fatalf("refs = %d after body closed twice, want 0", refs)
+	}
+}
+
+//分片小于dedupSampleSize时，严格秒传校验不能越过文件范围
+func TestStrictDedupSmallChunk(t *testing.T) {
+	for _, size := range []int{51, 100 * 1024, 200 * 1024} {
+		d := newFakeDrive(t)
+		content := make([]byte, size)
+		for i := range content {
+			content[i] = byte(i % 251)
+		}
+		d.addFile(RootFolderId, "origin.bin", content)
+		api := d.api(WithChunkSize(16), WithStrictDedup(true))
+		id, err := api.UploadBytes(content, "copy.bin", RootFolderId)
+		if err != nil {
+			t.Fatalf("UploadBytes(%d bytes duplicate) error = %v", size, err)
+		}
+		if f := d.file(id); f == nil || !bytes.Equal(f.data, content) {
+			t.Fatalf("deduplicated file %s of %d bytes was deleted or differs", id, size)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//将云盘作为webdav.FileSystem，可通过NewWebDAVHandler挂载为网络驱动器
+//PROPFIND对应GetFolder，GET对应下载，PUT对应上传，DELETE对应DeleteFile或DeleteFolderRecursive，MKCOL对应CreateFolder
+type WebDAVFS struct {
+	api Api
+}
+
+var _ webdav.FileSystem = (*WebDAVFS)(nil)
+
+func NewWebDAVFS(api Api) *WebDAVFS {
+	return &WebDAVFS{api: api}
+}
+
+//返回提供WebDAV服务的http.Handler，prefix为挂载的url前缀，如 /dav
+func NewWebDAVHandler(api Api, prefix string) http.Handler {
+	return &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: NewWebDAVFS(api),
+		LockSystem: webdav.NewMemLS(),
+	}
+}
+
+func (w *WebDAVFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	dir, base := splitPath(name)
+	if base == "" {
+		return os.ErrExist
+	}
+	parent, err := w.stat(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if !parent.IsFolder() {
+		return os.ErrNotExist
+	}
+	_, err = w.api.CreateFolder(base, parent.Id)
+	if errors.Is(err, ErrAlreadyExists) {
+		return os.ErrExist
+	}
+	return err
+}
+
+//只读打开时返回云盘中的文件或目录，写入时先写到本地临时文件，Close时再上传覆盖
+func (w *WebDAVFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return w.create(ctx, name, flag)
+	}
+	file, err := w.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if file.IsFolder() {
+		files, err := w.api.GetFolderContext(ctx, file.Id)
+		if err != nil {
+			return nil, err
+		}
+		return &webdavDir{info: fileInfo{file}, files: files}, nil
+	}
+	reader, err := w.api.OpenFile(file.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &webdavFile{info: fileInfo{file}, reader: reader}, nil
+}
+
+func (w *WebDAVFS) create(ctx context.Context, name string, flag int) (webdav.File, error) {
+	dir, base := splitPath(name)
+	if base == "" {
+		return nil, os.ErrInvalid
+	}
+	parent, err := w.stat(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsFolder() {
+		return nil, os.ErrNotExist
+	}
+	existed, err := w.stat(ctx, name)
+	if err == nil && existed.IsFolder() {
+		return nil, os.ErrExist
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil && flag&os.O_EXCL != 0 {
+		return nil, os.ErrExist
+	}
+	//临时文件与云盘中的文件同名，上传时直接使用其文件名，使用WithTempDir设置的目录
+	tmpDir, err := ioutil.TempDir(w.api.TempDir(), "micloud-webdav-")
+	if err != nil {
+		return nil, err
+	}
+	tmpFile, err := os.Create(filepath.Join(tmpDir, base))
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	//不截断时先下载已有内容
+	if existed != nil && flag&os.O_TRUNC == 0 {
+		if _, err := w.api.GetFileTo(existed.Id, tmpFile); err != nil {
+			tmpFile.Close()
+			_ = os.RemoveAll(tmpDir)
+			return nil, err
+		}
+		if flag&os.O_APPEND == 0 {
+			if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+				tmpFile.Close()
+				_ = os.RemoveAll(tmpDir)
+				return nil, err
+			}
+		}
+	}
+	return &webdavUpload{api: w.api, parentId: parent.Id, tmpDir: tmpDir, File: tmpFile}, nil
+}
+
+func (w *WebDAVFS) RemoveAll(ctx context.Context, name string) error {
+	file, err := w.stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	if file.Id == RootFolderId {
+		return os.ErrPermission
+	}
+	//目录需要先删除其中的内容
+	if file.IsFolder() {
+		return w.api.DeleteFolderRecursive(file.Id)
+	}
+	return w.api.DeleteFileContext(ctx, file.Id)
+}
+
+//目录不同时先移动再重命名
+func (w *WebDAVFS) Rename(ctx context.Context, oldName, newName string) error {
+	file, err := w.stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	if file.Id == RootFolderId {
+		return os.ErrPermission
+	}
+	oldDir, oldBase := splitPath(oldName)
+	newDir, newBase := splitPath(newName)
+	if newBase == "" {
+		return os.ErrInvalid
+	}
+	if oldDir != newDir {
+		parent, err := w.stat(ctx, newDir)
+		if err != nil {
+			return err
+		}
+		if !parent.IsFolder() {
+			return os.ErrNotExist
+		}
+		if err := w.api.Move(file.Id, parent.Id); err != nil {
+			return err
+		}
+	}
+	if oldBase != newBase {
+		return w.api.Rename(file.Id, newBase)
+	}
+	return nil
+}
+
+func (w *WebDAVFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	file, err := w.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{file}, nil
+}
+
+//按路径查找文件或目录，不存在时返回os.ErrNotExist
+func (w *WebDAVFS) stat(ctx context.Context, name string) (*File, error) {
+	dir, base := splitPath(name)
+	if base == "" {
+		return w.api.GetRoot()
+	}
+	parent, err := w.stat(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsFolder() {
+		return nil, os.ErrNotExist
+	}
+	files, err := w.api.GetFolderContext(ctx, parent.Id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	for _, v := range files {
+		if v.Name == base {
+			return v, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+//拆分为父目录和文件名，根目录的文件名为空
+func splitPath(name string) (string, string) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return "", ""
+	}
+	dir, base := path.Split(name)
+	return dir, base
+}
+
+//云盘中的文件，只读
+type webdavFile struct {
+	info   fileInfo
+	reader io.ReadSeekCloser
+}
+
+func (f *webdavFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+func (f *webdavFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *webdavFile) Close() error {
+	return f.reader.Close()
+}
+
+func (f *webdavFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *webdavFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *webdavFile) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+//云盘中的目录
+type webdavDir struct {
+	info   fileInfo
+	files  []*File
+	offset int
+}
+
+func (d *webdavDir) Read([]byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *webdavDir) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		d.offset = 0
+		return 0, nil
+	}
+	return 0, os.ErrInvalid
+}
+
+func (d *webdavDir) Close() error {
+	return nil
+}
+
+func (d *webdavDir) Readdir(count int) ([]os.FileInfo, error) {
+	remain := d.files[d.offset:]
+	if count > 0 && len(remain) == 0 {
+		return nil, io.EOF
+	}
+	if count <= 0 || count > len(remain) {
+		count = len(remain)
+	}
+	infos := make([]os.FileInfo, 0, count)
+	for _, v := range remain[:count] {
+		infos = append(infos, fileInfo{v})
+	}
+	d.offset += count
+	return infos, nil
+}
+
+func (d *webdavDir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *webdavDir) Write([]byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+//写入本地临时文件，Close时上传到parentId并覆盖同名文件
+type webdavUpload struct {
+	*os.File
+	api      Api
+	parentId string
+	tmpDir   string
+}
+
+func (f *webdavUpload) Readdir(int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *webdavUpload) Close() error {
+	defer os.RemoveAll(f.tmpDir)
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	_, err := f.api.UploadFileWithOptions(f.File.Name(), f.parentId, UploadOptions{OnConflict: OnConflictOverwrite})
+	return err
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWebDAVRemoveAllFolder(t *testing.T) {
+	d := newFakeDrive(t)
+	dirId := d.addFolder(RootFolderId, "dir")
+	subId := d.addFolder(dirId, "sub")
+	d.addFile(dirId, "a.txt", []byte("a"))
+	d.addFile(subId, "b.txt", []byte("b"))
+	fs := NewWebDAVFS(d.api())
+	if err := fs.RemoveAll(context.Background(), "/dir"); err != nil {
+		t.Fatalf("RemoveAll(/dir) error = %v", err)
+	}
+	if _, err := fs.Stat(context.Background(), "/dir"); !os.IsNotExist(err) {
+		t.Fatalf("Stat(/dir) after RemoveAll error = %v, want not exist", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.files) != 1 {
+		t.Fatalf("%d files left after RemoveAll, want only root", len(d.files))
+	}
+}
+
+func TestWebDAVUploadUsesTempDir(t *testing.T) {
+	d := newFakeDrive(t)
+	tempDir := t.TempDir()
+	fs := NewWebDAVFS(d.api(WithTempDir(tempDir)))
+	file, err := fs.OpenFile(context.Background(), "/a.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, ok := file.(*webdavUpload)
+	if !ok {
+		t.Fatalf("OpenFile() returned %T, want *webdavUpload", file)
+	}
+	if !strings.HasPrefix(upload.Name(), tempDir+string(filepath.Separator)) {
+		t.Fatalf("upload buffered at %s, want under %s", upload.Name(), tempDir)
+	}
+	if _, err := file.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ioutil.ReadDir(tempDir); len(entries) != 0 {
+		t.Fatalf("%d entries left in temp dir after upload", len(entries))
+	}
+	if info, err := fs.Stat(context.Background(), "/a.txt"); err != nil || info.Size() != 5 {
+		t.Fatalf("Stat(/a.txt) = %v, %v, want 5 bytes", info, err)
+	}
+}
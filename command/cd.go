@@ -29,7 +29,7 @@ func Cd() *cli.Command {
 			}
 			dir = strings.ReplaceAll(dir, "\\s", " ")
 			file, ok := FileMap[dir]
-			if !ok || file.Type != "folder" {
+			if !ok || !file.IsFolder() {
 				return errors.New("目录不存在")
 			}
 			DirList = append(DirList, file.Id)
@@ -3,7 +3,6 @@ package command
 import (
 	"fmt"
 	"github.com/urfave/cli/v2"
-	"go-micloud/api"
 	"go-micloud/config"
 	"os"
 	"strings"
@@ -23,12 +22,12 @@ func Download() *cli.Command {
 					fmt.Println("===> 当前目录不存在该文件！")
 					continue
 				}
-				if fileInfo.Type == "folder" {
+				if fileInfo.IsFolder() {
 					fmt.Println("===> 目前不支持下载文件夹！")
 					continue
 				}
 				fmt.Println("===> 开始下载！")
-				file, err := api.FileApi.GetFile(fileInfo.Id)
+				file, err := fileApi.GetFile(fileInfo.Id)
 				if err != nil {
 					fmt.Printf("===> 下载失败！Error: %s\n", err)
 					continue
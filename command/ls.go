@@ -6,10 +6,14 @@ import (
 	"github.com/urfave/cli/v2"
 	"go-micloud/api"
 	"go-micloud/lib/color"
-	"go-micloud/lib/function"
 	"go-micloud/lib/line"
+	"go-micloud/user"
 )
 
+var account = user.NewUser()
+
+var fileApi = api.NewApi(account)
+
 var DirList []string
 
 var FileMap map[string]*api.File
@@ -19,12 +23,12 @@ func List() *cli.Command {
 		Name:  "ls",
 		Usage: "List all files",
 		Action: func(context *cli.Context) error {
-			var folderId = "0"
+			var folderId = api.RootFolderId
 			dirNum := len(DirList)
 			if dirNum > 0 {
 				folderId = DirList[dirNum-1]
 			}
-			files, err := api.FileApi.GetFolder(folderId)
+			files, err := fileApi.GetFolder(folderId)
 			if err != nil {
 				return err
 			}
@@ -44,9 +48,9 @@ func format(files []*api.File) {
 	var words []string
 	for _, v := range files {
 		if v.Type == "file" {
-			fmt.Printf("- | %-6s | %s | %s\n", humanize.Bytes(uint64(v.Size)), function.FormatTimeInt(int64(v.CreateTime), true), v.Name)
+			fmt.Printf("- | %-6s | %s | %s\n", humanize.Bytes(uint64(v.Size)), v.CreateTime.Format("2006-01-02 15:04:05"), v.Name)
 		} else {
-			fmt.Printf("d | ------ | %s | %s\n", function.FormatTimeInt(int64(v.CreateTime), true), color.Blue(v.Name))
+			fmt.Printf("d | ------ | %s | %s\n", v.CreateTime.Format("2006-01-02 15:04:05"), color.Blue(v.Name))
 		}
 		FileMap[v.Name] = v
 		words = append(words, v.Name)
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/tidwall/gjson"
 	"github.com/urfave/cli/v2"
-	"go-micloud/api"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -24,11 +23,11 @@ func Share() *cli.Command {
 					fmt.Printf("===> 当前目录不存在该文件！\n")
 					continue
 				}
-				if fileInfo.Type == "folder" {
+				if fileInfo.IsFolder() {
 					fmt.Printf("===> 目前不支持分享文件夹！\n")
 					continue
 				}
-				downloadUrl, err := api.FileApi.GetFileDownLoadUrl(fileInfo.Id)
+				downloadUrl, err := fileApi.GetFileDownLoadUrl(fileInfo.Id)
 				if err != nil {
 					fmt.Printf("===> 获取失败！Error: %s\n", err)
 					continue
@@ -3,7 +3,6 @@ package command
 import (
 	"fmt"
 	"github.com/urfave/cli/v2"
-	"go-micloud/api"
 	"os"
 	"strings"
 )
@@ -31,7 +30,7 @@ func Upload() *cli.Command {
 					continue
 				}
 				fmt.Println("===> 开始上传！")
-				_, err = api.FileApi.UploadFile(fileName, DirList[len(DirList)-1])
+				_, err = fileApi.UploadFile(fileName, DirList[len(DirList)-1])
 				if err != nil {
 					fmt.Printf("===> 上传失败！Error: %s\n", err)
 				} else {
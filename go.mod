@@ -1,6 +1,6 @@
 module go-micloud
 
-go 1.12
+go 1.16
 
 require (
 	github.com/dustin/go-humanize v1.0.0
@@ -11,6 +11,7 @@ require (
 	github.com/tidwall/gjson v1.3.5
 	github.com/urfave/cli/v2 v2.0.0
 	go.uber.org/zap v1.13.0
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
 	gopkg.in/ini.v1 v1.51.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
@@ -0,0 +1,31 @@
+package user
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+//可并发替换的cookie jar，更新cookies时替换内部的jar，避免与进行中的请求竞争HttpClient.Jar
+type safeJar struct {
+	mu  sync.RWMutex
+	jar http.CookieJar
+}
+
+func (j *safeJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	j.jar.SetCookies(u, cookies)
+}
+
+func (j *safeJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.jar.Cookies(u)
+}
+
+func (j *safeJar) set(jar http.CookieJar) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jar = jar
+}
@@ -8,12 +8,14 @@ import (
 	"go-micloud/config"
 	"go-micloud/lib/function"
 	"go-micloud/lib/zlog"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -33,26 +35,23 @@ type User struct {
 	IsLogin      bool
 	UserId       string
 	ServiceToken string
+	renewalOnce  sync.Once
+	refreshMu    sync.Mutex
+	//保护IsLogin、UserId和ServiceToken，并发访问时使用LoggedIn、Token等方法
+	tokenMu sync.RWMutex
 }
 
-var Account *User
-
-func init() {
-	Account = NewUser()
-	go func() {
-		ticker := time.NewTicker(time.Second * 30)
-		for {
-			select {
-			case <-ticker.C:
-				if Account.IsLogin {
-					err := Account.autoRenewal()
-					if err != nil {
-						fmt.Printf("autoRenewal error: %s", err)
-					}
-				}
-			}
-		}
-	}()
+var (
+	defaultAccount *User
+	accountOnce    sync.Once
+)
+
+// Deprecated: 使用NewUser创建用户，Account仅为兼容保留，首次调用时才创建
+func Account() *User {
+	accountOnce.Do(func() {
+		defaultAccount = NewUser()
+	})
+	return defaultAccount
 }
 
 func NewUser() *User {
@@ -63,23 +62,117 @@ func NewUser() *User {
 				return http.ErrUseLastResponse
 			},
 			Transport: zlog.HttpLoggerTransport,
-			Jar:       jar,
+			Jar:       &safeJar{jar: jar},
 		},
 	}
 }
 
+//登录成功后定时续期，每个用户只启动一次
+func (u *User) startAutoRenewal() {
+	u.renewalOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Second * 30)
+			for range ticker.C {
+				if u.LoggedIn() {
+					err := u.autoRenewal()
+					if err != nil {
+						fmt.Printf("autoRenewal error: %s", err)
+					}
+				}
+			}
+		}()
+	})
+}
+
 func (u *User) autoRenewal() error {
 	var apiUrl = fmt.Sprintf(autoRenewal, strconv.Itoa(int(time.Now().UnixNano()))[0:13])
 	resp, err := u.HttpClient.Get(apiUrl)
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 	if len(resp.Cookies()) > 0 {
 		u.updateCookies(imi, resp.Cookies())
 	}
 	return nil
 }
 
+//并发读取serviceToken，RefreshToken会在请求过程中更新serviceToken
+func (u *User) Token() string {
+	u.tokenMu.RLock()
+	defer u.tokenMu.RUnlock()
+	return u.ServiceToken
+}
+
+//是否已登录，RefreshToken发现登录过期时会并发修改
+func (u *User) LoggedIn() bool {
+	u.tokenMu.RLock()
+	defer u.tokenMu.RUnlock()
+	return u.IsLogin
+}
+
+func (u *User) setLogin(isLogin bool) {
+	u.tokenMu.Lock()
+	defer u.tokenMu.Unlock()
+	u.IsLogin = isLogin
+}
+
+func (u *User) userId() string {
+	u.tokenMu.RLock()
+	defer u.tokenMu.RUnlock()
+	return u.UserId
+}
+
+//更新userId和serviceToken，参数为空时保留原值
+func (u *User) setToken(userId, serviceToken string) {
+	u.tokenMu.Lock()
+	defer u.tokenMu.Unlock()
+	if userId != "" {
+		u.UserId = userId
+	}
+	if serviceToken != "" {
+		u.ServiceToken = serviceToken
+	}
+}
+
+//替换cookies，HttpClient使用默认的jar时只替换jar的内容
+func (u *User) setJar(jar http.CookieJar) {
+	if j, ok := u.HttpClient.Jar.(*safeJar); ok {
+		j.set(jar)
+		return
+	}
+	u.HttpClient.Jar = jar
+}
+
+var ErrorLoginExpired = errors.New("登录已过期，请重新登录")
+
+//续期后从cookies中读取新的serviceToken，仍未登录时返回ErrorLoginExpired
+func (u *User) RefreshToken() error {
+	u.refreshMu.Lock()
+	defer u.refreshMu.Unlock()
+	if err := u.autoRenewal(); err != nil {
+		return err
+	}
+	parseUrl, err := url.Parse(imi)
+	if err != nil {
+		return err
+	}
+	for _, v := range u.HttpClient.Jar.Cookies(parseUrl) {
+		if v.Name == "serviceToken" {
+			u.setToken("", v.Value)
+		}
+	}
+	result, err := u.CheckPhoneCode()
+	if err != nil {
+		return err
+	}
+	if result != "" {
+		u.setLogin(false)
+		return ErrorLoginExpired
+	}
+	return nil
+}
+
 // 手动录入cookies登录
 func (u *User) LoginManual() error {
 	jar, err := cookiejar.New(nil)
@@ -110,16 +203,16 @@ func (u *User) LoginManual() error {
 		Path:   "/",
 	}
 	jar.SetCookies(parseUrl, cookies)
-	u.HttpClient.Jar = jar
+	u.setJar(jar)
 
 	result, err := u.CheckPhoneCode()
 	if err != nil {
 		return err
 	}
 	if result == "" {
-		u.UserId = cUserId
-		u.ServiceToken = cServiceToken
-		u.IsLogin = true
+		u.setToken(cUserId, cServiceToken)
+		u.setLogin(true)
+		u.startAutoRenewal()
 		return nil
 	} else {
 		return errors.New("登录失败，请重试")
@@ -175,10 +268,10 @@ func (u *User) Login(input bool) error {
 	cookies := u.HttpClient.Jar.Cookies(parseUrl)
 	for _, v := range cookies {
 		if v.Name == "userId" {
-			u.UserId = v.Value
+			u.setToken(v.Value, "")
 		}
 		if v.Name == "serviceToken" {
-			u.ServiceToken = v.Value
+			u.setToken("", v.Value)
 		}
 	}
 	result, err := u.CheckPhoneCode()
@@ -186,12 +279,14 @@ func (u *User) Login(input bool) error {
 		return err
 	}
 	if result == "" {
-		u.IsLogin = true
+		u.setLogin(true)
+		u.startAutoRenewal()
 		return nil
 	}
 	err = u.SendPhoneCode(result)
 	if err == ErrorNotNeedSms {
-		u.IsLogin = true
+		u.setLogin(true)
+		u.startAutoRenewal()
 		fmt.Println("===> 登录成功！")
 		go saveAccount(username, password)
 		return nil
@@ -208,7 +303,8 @@ func (u *User) Login(input bool) error {
 			return err
 		}
 		if result == "" {
-			u.IsLogin = true
+			u.setLogin(true)
+			u.startAutoRenewal()
 			fmt.Println("===> 登录成功！")
 			go saveAccount(username, password)
 			return nil
@@ -247,6 +343,7 @@ func (u *User) serviceLogin() error {
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 	u.updateCookies(xiaomi, resp.Cookies())
 	return nil
 }
@@ -292,20 +389,24 @@ func (u *User) passServiceLogin(location string) error {
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 	resp, err = u.HttpClient.Get(resp.Header.Get("Location"))
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 	resp, err = u.HttpClient.Get(resp.Header.Get("Location"))
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 	u.updateCookies(xiaomi, resp.Cookies())
 
 	resp, err = u.HttpClient.Get(resp.Header.Get("Location"))
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 
 	u.updateCookies(xiaomi, resp.Cookies())
 
@@ -313,6 +414,7 @@ func (u *User) passServiceLogin(location string) error {
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 	return nil
 }
 
@@ -342,6 +444,7 @@ func (u *User) SendPhoneCode(location string) error {
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 	u.updateCookies(xiaomi, resp.Cookies())
 
 	location = resp.Header.Get("Location")
@@ -349,6 +452,7 @@ func (u *User) SendPhoneCode(location string) error {
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 	if strings.Contains(location, "i.mi.com") {
 		u.updateCookies(imi, resp.Cookies())
 		return ErrorNotNeedSms
@@ -357,7 +461,7 @@ func (u *User) SendPhoneCode(location string) error {
 
 	apiUrl := fmt.Sprintf(sendPhoneCode, strconv.Itoa(int(time.Now().UnixNano()))[0:13])
 	form := url.Values{}
-	form.Add("user", u.UserId)
+	form.Add("user", u.userId())
 	form.Add("retry", "0")
 	resp, err = u.HttpClient.PostForm(apiUrl, form)
 	if err != nil {
@@ -386,7 +490,7 @@ func (u *User) VerifyPhoneCode(code string) error {
 	var apiUrl = fmt.Sprintf(verifyPhoneCode, strconv.Itoa(int(time.Now().UnixNano()))[0:13])
 	form := url.Values{}
 	form.Add("_json", "true")
-	form.Add("user", u.UserId)
+	form.Add("user", u.userId())
 	form.Add("ticket", code)
 	form.Add("trust", "true")
 	request, err := http.NewRequest("POST", apiUrl, strings.NewReader(form.Encode()))
@@ -437,6 +541,7 @@ func (u *User) VerifyPhoneCode(code string) error {
 	if err != nil {
 		return err
 	}
+	closeBody(resp)
 
 	return nil
 }
@@ -444,7 +549,11 @@ func (u *User) VerifyPhoneCode(code string) error {
 func (u *User) updateCookies(domain string, newCookies []*http.Cookie) {
 	jar, _ := cookiejar.New(nil)
 	parseUrl, _ := url.Parse(domain)
-	var oldCookies = u.HttpClient.Jar.Cookies(parseUrl)
+	var (
+		oldCookies   = u.HttpClient.Jar.Cookies(parseUrl)
+		userId       = u.userId()
+		serviceToken = u.Token()
+	)
 	for _, v := range newCookies {
 		var existed = false
 		for _, k := range oldCookies {
@@ -466,16 +575,16 @@ func (u *User) updateCookies(domain string, newCookies []*http.Cookie) {
 			validCookies = append(validCookies, c)
 		}
 		// 更新配置文件
-		if c.Name == "userId" && c.Value != u.UserId {
+		if c.Name == "userId" && c.Value != userId {
 			config.Conf.Section("XIAOMI").Key("USER_ID").SetValue(c.Value)
 		}
-		if c.Name == "serviceToken" && c.Value != u.ServiceToken {
+		if c.Name == "serviceToken" && c.Value != serviceToken {
 			config.Conf.Section("XIAOMI").Key("SERVICE_TOKEN").SetValue(c.Value)
 		}
 		go config.SaveToFile()
 	}
 	jar.SetCookies(parseUrl, validCookies)
-	u.HttpClient.Jar = jar
+	u.setJar(jar)
 }
 
 func saveDeviceId(v *http.Cookie) {
@@ -496,3 +605,9 @@ func saveAccount(username, password string) {
 	account.Key("PASSWORD").SetValue(secretPwd)
 	go config.SaveToFile()
 }
+
+//读取剩余内容后关闭响应，连接才能被复用，cookies和header在关闭后仍可读取
+func closeBody(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+}